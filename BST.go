@@ -1,159 +1,386 @@
 package main
 
 import (
-	"fmt"
 	"math"
+	"sort"
 )
 
 // BSPTree is a 2d spatial index of Point objects.
 
 type BSPTreePoint struct {
 	*Point
-	cnt int
+	cnt    int
+	weight float64 // Sum of the weights of the cnt points
+	id     int     // Location id, see IDs
 }
 
 type BSPTree struct {
-	cnt   int // How many points are there in this node?
-	size  int // How many points are in the tree in total?
-	rect  Rect
-	point *Point
-	left  *BSPTree
-	right *BSPTree
+	cnt    int     // How many points are there in this node?
+	weight float64 // Sum of the weights of the points in this node
+	size   int     // How many points are in the tree in total?
+	rect   Rect
+	point  *Point
+	id     int  // Id of the location of point
+	ids    *int // Ids handed out so far, shared by every node of the tree
+	left   *BSPTree
+	right  *BSPTree
 }
 
 // Create a new BSPTree
 func NewBSPTree(x, y, w, h float64) *BSPTree {
 	return &BSPTree{
 		rect: Rect{x, y, w, h},
+		ids:  new(int),
 	}
 }
 
+// Node of the same tree
+func (q *BSPTree) child(x, y, w, h float64) *BSPTree {
+	return &BSPTree{rect: Rect{x, y, w, h}, ids: q.ids}
+}
+
+// Upper bound of the location ids
+// Every location gets the next id when it is inserted, so the ids are dense (0 to IDs() - 1)
+// until points are removed. Points inserted at an existing location share its id.
+func (q *BSPTree) IDs() int {
+	return *q.ids
+}
+
 // Create a new BSPTree
 func NewBSPTreeFromPoints(r Rect, points *[]Point) *BSPTree {
+	return NewBSPTreeFromWeightedPoints(r, points, nil)
+}
+
+// Create a new BSPTree where every point has a weight (nil weights are all 1)
+func NewBSPTreeFromWeightedPoints(r Rect, points *[]Point, weights []float64) *BSPTree {
 	tree := NewBSPTree(r.x, r.y, r.w, r.h)
 	for i := 0; i < len(*points); i++ {
 		p := &(*points)[i]
-		tree.Insert(p)
+		if weights == nil {
+			tree.Insert(p)
+		} else {
+			tree.InsertWeighted(p, weights[i])
+		}
 	}
 	return tree
 }
 
 // Tree insert
+// Points outside of the tree bounds grow the tree until they fit
 func (q *BSPTree) Insert(p *Point) {
+	q.InsertWeighted(p, 1)
+}
+
+// Tree insert of a point with a weight
+func (q *BSPTree) InsertWeighted(p *Point, weight float64) {
+	q.grow(p)
+	q.insert(p, weight)
+}
+
+func (q *BSPTree) insert(p *Point, weight float64) {
 	q.size++
 	if q.point == nil && q.left == nil && q.right == nil { // Try normal insert
 		q.point = p
 		q.cnt = 1
+		q.weight = weight
+		q.id = *q.ids
+		*q.ids++
 	} else if q.left != nil && q.right != nil { // Find closes quadrant
-		distLeft := p.Distance(q.left.rect.Centroid())
-		distRight := p.Distance(q.right.rect.Centroid())
-		if distLeft < distRight {
-			q.left.Insert(p)
-		} else {
-			q.right.Insert(p)
-		}
+		child := q.childFor(p)
+		child.cover(p)
+		child.insert(p, weight)
 	} else if q.point != nil && q.point.Distance(*p) == 0 { // If point is in the exact same place, add it to the tree
 		q.cnt++
+		q.weight += weight
 	} else { // Subdivide
-		q.Subdivide(p)
+		q.Subdivide(p, weight)
 	}
 }
 
 // Subdivide tree while adding point
-func (q *BSPTree) Subdivide(p *Point) {
+func (q *BSPTree) Subdivide(p *Point, weight float64) {
 	// Initialize the quadrants
-	// If rect is vertical rectangle split vertically, else split horizontally
-	ratio := q.rect.w / q.rect.h
-	if ratio >= 1 { // Split vertically
-		w := q.rect.w / 2
-		q.left = NewBSPTree(q.rect.x, q.rect.y, w, q.rect.h)
-		q.right = NewBSPTree(q.rect.x+w, q.rect.y, w, q.rect.h)
-	} else { // Split horizontally
-		h := q.rect.h / 2
-		q.left = NewBSPTree(q.rect.x, q.rect.y, q.rect.w, h)
-		q.right = NewBSPTree(q.rect.x, q.rect.y+h, q.rect.w, h)
-	}
+	left, right := q.rect.halves()
+	q.left = q.child(left.x, left.y, left.w, left.h)
+	q.right = q.child(right.x, right.y, right.w, right.h)
 
 	// Add points to their respective quadrants
-	distLeft := q.point.Distance(q.left.rect.Centroid())
-	distRight := q.point.Distance(q.right.rect.Centroid())
-
-	var toInsert *BSPTree
-	if distLeft < distRight {
-		toInsert = q.left
-	} else {
-		toInsert = q.right
-	}
-
+	toInsert := q.closestChild(q.point)
 	toInsert.point = q.point
 	toInsert.cnt = q.cnt
+	toInsert.weight = q.weight
+	toInsert.id = q.id
 	toInsert.size = q.cnt // Subtract the point we just added (we are inserting a new point)
+	toInsert.cover(q.point)
 
-	distLeft = p.Distance(q.left.rect.Centroid())
-	distRight = p.Distance(q.right.rect.Centroid())
-
-	if distLeft < distRight {
-		q.left.Insert(p)
-	} else {
-		q.right.Insert(p)
-	}
+	child := q.closestChild(p)
+	child.cover(p)
+	child.insert(p, weight)
 
 	q.point = nil // Clear the point (it's been inserted into the children)
 	q.cnt = 0
+	q.weight = 0
 }
 
-func (q *BSPTree) Rebuild(p *Point) {
-	fmt.Println("Warning: rebuilding tree!!!")
-	// Get all points in the tree
-	points := q.Query(q.rect)
+// Grow the root until it contains the point
+// The current root is pushed down as one half of a new root twice its size,
+// which keeps every point where it is instead of rebuilding the whole tree
+func (q *BSPTree) grow(p *Point) {
+	if math.IsNaN(p.x) || math.IsNaN(p.y) || math.IsInf(p.x, 0) || math.IsInf(p.y, 0) {
+		return // Would never fit
+	}
+
+	for !rectPointIntersect(q.rect, *p) {
+		r := q.rect
+		outX := p.x < r.x || p.x > r.x+r.w
+		outY := p.y < r.y || p.y > r.y+r.h
 
-	// Get bounding box of all points including the new point
-	var minX, minY, maxX, maxY float64 = math.MaxFloat64, math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
-	allPoints := make([]*Point, len(points))
-	allPoints = append(allPoints, p)
-	for _, bspPoint := range points {
-		for i := 0; i < bspPoint.cnt; i++ {
-			allPoints = append(allPoints, bspPoint.Point)
+		if q.left == nil { // A leaf holds at most one position, just stretch the bounds
+			q.rect = r.MergePoint(*p)
+			continue
 		}
-	}
-	for _, p := range allPoints {
-		if p.x < minX {
-			minX = p.x
+		if outX && r.w == 0 { // Every split is horizontal, so the width can change freely
+			q.stretch(r.MergePoint(Point{p.x, r.y}))
+			continue
 		}
-		if p.y < minY {
-			minY = p.y
+		if outY && r.h == 0 { // Same for the height
+			q.stretch(r.MergePoint(Point{r.x, p.y}))
+			continue
 		}
-		if p.x > maxX {
-			maxX = p.x
+
+		var sibling Rect
+		if outX {
+			if p.x < r.x {
+				sibling = Rect{r.x - r.w, r.y, r.w, r.h}
+			} else {
+				sibling = Rect{r.x + r.w, r.y, r.w, r.h}
+			}
+		} else {
+			if p.y < r.y {
+				sibling = Rect{r.x, r.y - r.h, r.w, r.h}
+			} else {
+				sibling = Rect{r.x, r.y + r.h, r.w, r.h}
+			}
 		}
-		if p.y > maxY {
-			maxY = p.y
+
+		// Keep left/right in x/y order like Subdivide does
+		old := *q
+		*q = BSPTree{size: old.size, rect: r.Merge(sibling), ids: old.ids}
+		if sibling.x < r.x || sibling.y < r.y {
+			q.left = old.child(sibling.x, sibling.y, sibling.w, sibling.h)
+			q.right = &old
+		} else {
+			q.left = &old
+			q.right = old.child(sibling.x, sibling.y, sibling.w, sibling.h)
 		}
 	}
+}
 
-	// Create new bounding box with padding
-	q.rect = Rect{minX - 1, minY - 1, maxX - minX + 2, maxY - minY + 2}
-	q.point = nil
-	q.left = nil
-	q.right = nil
+// Replaces the flat dimension of a subtree bounds
+func (q *BSPTree) stretch(r Rect) {
+	if q == nil {
+		return
+	}
+	if q.rect.w == 0 {
+		q.rect.x, q.rect.w = r.x, r.w
+	}
+	if q.rect.h == 0 {
+		q.rect.y, q.rect.h = r.y, r.h
+	}
+	q.left.stretch(r)
+	q.right.stretch(r)
+}
+
+// Returns the child a point goes to: the one whose bounds contain it
+// A point on the edge between both children goes to the one that already holds it. The edge
+// of a grown root is the far edge of the old root, its points can be on either side.
+func (q *BSPTree) childFor(p *Point) *BSPTree {
+	inLeft := rectPointIntersect(q.left.rect, *p)
+	inRight := rectPointIntersect(q.right.rect, *p)
+	switch {
+	case inLeft && !inRight:
+		return q.left
+	case inRight && !inLeft:
+		return q.right
+	case inLeft && q.left.holds(p):
+		return q.left
+	case inRight && q.right.holds(p):
+		return q.right
+	}
+	return q.closestChild(p)
+}
+
+// Stretches the bounds of a node over a point it is given
+// The halves of a rect (and the bounds of grown roots) are rounded, so a point on the edge of its
+// parent can be a rounding step outside of the child it goes to. Stretching keeps every point inside
+// the bounds of every node above it: childFor finds it there again and queries don't skip it.
+func (q *BSPTree) cover(p *Point) {
+	if rectPointIntersect(q.rect, *p) || math.IsNaN(p.x) || math.IsNaN(p.y) || math.IsInf(p.x, 0) || math.IsInf(p.y, 0) {
+		return
+	}
+	q.rect = q.rect.MergePoint(*p)
+}
+
+// Is there a point at this location in the tree?
+func (q *BSPTree) holds(p *Point) bool {
+	for q != nil && q.left != nil && q.right != nil {
+		q = q.childFor(p)
+	}
+	return q != nil && q.point != nil && pointIntersect(*q.point, *p)
+}
+
+// Returns the child whose centroid is closest to the point (ties go right)
+func (q *BSPTree) closestChild(p *Point) *BSPTree {
+	distLeft := p.Distance(q.left.rect.Centroid())
+	distRight := p.Distance(q.right.rect.Centroid())
+	if distLeft < distRight {
+		return q.left
+	}
+	return q.right
+}
+
+// Tree remove
+// Removes one occurrence of the point, returns false if it is not in the tree
+func (q *BSPTree) Remove(p *Point) bool {
+	return q.RemoveWeighted(p, 1)
+}
+
+// Tree remove of a point inserted with a weight
+func (q *BSPTree) RemoveWeighted(p *Point, weight float64) bool {
+	if q == nil {
+		return false
+	}
+
+	if q.left != nil && q.right != nil { // Points always live in the child Insert would pick
+		if !q.childFor(p).RemoveWeighted(p, weight) {
+			return false
+		}
+		q.size--
+		q.collapse()
+		return true
+	}
+
+	if q.point == nil || !pointIntersect(*q.point, *p) {
+		return false
+	}
+
+	q.cnt--
+	q.weight -= weight
+	q.size--
+	if q.cnt == 0 {
+		q.point = nil
+		q.weight = 0
+	}
+	return true
+}
+
+// Moves one occurrence of a point to a new position
+// Returns false (and leaves the tree untouched) if the old point is not in the tree
+func (q *BSPTree) Move(from *Point, to *Point) bool {
+	if !q.Remove(from) {
+		return false
+	}
+	q.Insert(to)
+	return true
+}
+
+// Undo a subdivision once the children no longer need it
+func (q *BSPTree) collapse() {
+	if q.size == 0 { // Nothing left, drop the whole subtree
+		q.left = nil
+		q.right = nil
+		return
+	}
 
-	// Re-add all points to the tree
-	for _, pts := range allPoints {
-		q.Insert(pts)
+	// Only merge leaves, otherwise we would have to re-route the grandchildren
+	if q.left.left != nil || q.right.left != nil {
+		return
 	}
+
+	// A single remaining leaf goes back into this node, as if it was never subdivided
+	var leaf *BSPTree
+	if q.left.size == 0 {
+		leaf = q.right
+	} else if q.right.size == 0 {
+		leaf = q.left
+	} else {
+		return
+	}
+
+	q.point = leaf.point
+	q.cnt = leaf.cnt
+	q.weight = leaf.weight
+	q.id = leaf.id
+	q.left = nil
+	q.right = nil
 }
 
 func (q *BSPTree) Query(r Rect) []BSPTreePoint {
-	c := q.QueryAsync(r)
-	var points []BSPTreePoint
-	for p := range c {
-		points = append(points, p)
+	return q.queryAppend(r, nil)
+}
+
+// Same as QueryChan, without the channel (and the goroutine) that would cost more than the query on small rects
+func (q *BSPTree) queryAppend(r Rect, points []BSPTreePoint) []BSPTreePoint {
+	if q == nil || !rectIntersect(q.rect, r) { // Skip nodes outside of the query
+		return points
+	}
+
+	points = q.left.queryAppend(r, points)
+	points = q.right.queryAppend(r, points)
+
+	if q.point != nil && rectPointIntersect(r, *q.point) {
+		points = append(points, BSPTreePoint{q.point, q.cnt, q.weight, q.id})
 	}
 	return points
 }
 
+// Appends the locations inside r to points
+func (q *BSPTree) RangeQuery(r Rect, points []BSPTreePoint) []BSPTreePoint {
+	return q.queryAppend(r, points)
+}
+
+// Number of points in the tree
+func (q *BSPTree) Size() int {
+	return q.size
+}
+
+// Bounds of the tree, every point is inside
+func (q *BSPTree) Bounds() Rect {
+	return q.rect
+}
+
+// Appends the locations within radius of p to points
+func (q *BSPTree) RadiusQuery(p Point, radius float64, points []BSPTreePoint) []BSPTreePoint {
+	start := len(points)
+	points = q.queryAppend(radiusRect(p, radius), points)
+	within := points[:start]
+	for _, n := range points[start:] { // Drop the corners of the square
+		if n.Point.Distance(p) <= radius {
+			within = append(within, n)
+		}
+	}
+	return within
+}
+
+// Sum of the weights of the points within radius of p, stops once the sum reaches limit
+// Cheaper than a query when only the density matters (dense areas stop after a few points).
+func (q *BSPTree) WeightWithin(p Point, radius float64, limit float64) float64 {
+	return q.weightWithin(p, radiusRect(p, radius), radius, limit, 0)
+}
+
+func (q *BSPTree) weightWithin(p Point, r Rect, radius float64, limit float64, weight float64) float64 {
+	if q == nil || weight >= limit || !rectIntersect(q.rect, r) {
+		return weight
+	}
+	if q.point != nil && q.point.Distance(p) <= radius {
+		weight += q.weight
+	}
+	weight = q.left.weightWithin(p, r, radius, limit, weight)
+	return q.right.weightWithin(p, r, radius, limit, weight)
+}
+
 func (q *BSPTree) QueryChan(r Rect, c chan BSPTreePoint) {
-	if q == nil {
+	if q == nil || !rectIntersect(q.rect, r) { // Skip nodes outside of the query
 		return
 	}
 
@@ -161,10 +388,89 @@ func (q *BSPTree) QueryChan(r Rect, c chan BSPTreePoint) {
 	q.right.QueryChan(r, c)
 
 	if q.point != nil && rectPointIntersect(r, *q.point) {
-		c <- BSPTreePoint{q.point, q.cnt}
+		c <- BSPTreePoint{q.point, q.cnt, q.weight, q.id}
 	}
 }
 
+// Returns the points closest to p, nearest first, until their weights add up to k
+// (or the tree runs out of points). Searches squares of growing size around p.
+func (q *BSPTree) Nearest(p Point, k float64) []BSPTreePoint {
+	if q.size == 0 || k <= 0 {
+		return nil
+	}
+
+	// Start with the radius that would hold k points if they were spread evenly
+	r := math.Sqrt(q.rect.w * q.rect.h * math.Min(k, float64(q.size)) / float64(q.size) / math.Pi)
+	if r == 0 || math.IsNaN(r) || math.IsInf(r, 0) {
+		r = math.Max(q.rect.w, q.rect.h) / 2
+	}
+	if r == 0 {
+		r = 1
+	}
+
+	for {
+		square := Rect{p.x - r, p.y - r, r * 2, r * 2}
+		found := q.Query(square)
+		everything := square.x <= q.rect.x && square.y <= q.rect.y &&
+			square.x+square.w >= q.rect.x+q.rect.w && square.y+square.h >= q.rect.y+q.rect.h
+
+		// Only the points inside the circle are sure to be closer than the ones we have not seen yet
+		within := []BSPTreePoint{}
+		weight := 0.0
+		for _, n := range found {
+			if everything || n.Point.Distance(p) <= r {
+				within = append(within, n)
+				weight += n.weight
+			}
+		}
+
+		if weight >= k || everything {
+			sort.Slice(within, func(i, j int) bool {
+				return within[i].Point.Distance(p) < within[j].Point.Distance(p)
+			})
+			weight = 0
+			for i, n := range within {
+				weight += n.weight
+				if weight >= k {
+					return within[:i+1]
+				}
+			}
+			return within
+		}
+		r *= 2
+	}
+}
+
+// Returns the k locations closest to p, nearest first
+// Unlike Nearest, every location counts once whatever its weight. Visits the closest child first
+// and skips the subtrees that are further away than the k-th location found so far.
+func (q *BSPTree) KNN(p Point, k int) []BSPTreePoint {
+	if k <= 0 {
+		return nil
+	}
+	res := newKNNResult(p, k)
+	q.knn(res)
+	return res.points
+}
+
+func (q *BSPTree) knn(res *knnResult) {
+	if q == nil || q.size == 0 || q.rect.Distance(res.p) > res.worst() {
+		return
+	}
+	if q.point != nil {
+		res.add(BSPTreePoint{q.point, q.cnt, q.weight, q.id})
+	}
+	if q.left == nil || q.right == nil {
+		return
+	}
+	first, second := q.left, q.right
+	if q.right.rect.Distance(res.p) < q.left.rect.Distance(res.p) {
+		first, second = second, first
+	}
+	first.knn(res)
+	second.knn(res)
+}
+
 // Returns an iterator over a query
 func (q *BSPTree) QueryAsync(r Rect) <-chan BSPTreePoint {
 	c := make(chan BSPTreePoint, q.size)
@@ -178,6 +484,27 @@ func (q *BSPTree) QueryAsync(r Rect) <-chan BSPTreePoint {
 }
 
 // Iterate over all points
+// Walks the whole subtree instead of querying its rect.
 func (q *BSPTree) Iterate() <-chan BSPTreePoint {
-	return q.QueryAsync(q.rect)
+	c := make(chan BSPTreePoint, q.size)
+
+	go func() {
+		q.iterateChan(c)
+		close(c)
+	}()
+
+	return c
+}
+
+func (q *BSPTree) iterateChan(c chan BSPTreePoint) {
+	if q == nil {
+		return
+	}
+
+	q.left.iterateChan(c)
+	q.right.iterateChan(c)
+
+	if q.point != nil {
+		c <- BSPTreePoint{q.point, q.cnt, q.weight, q.id}
+	}
 }
@@ -1,20 +1,39 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
 
-// data.csv has exactly 232050 points
-const NUMBER_OF_POINTS = 232050
+	"dbscan/generate"
+)
+
+// The test file has exactly 23050 points
+const NUMBER_OF_POINTS = 23050
+
+// Writes a generated dataset in the input format, so the tests don't need the real data.csv
+func testCSV(t *testing.T) string {
+	t.Helper()
+	g := generate.New(1)
+	d := g.Shuffle(generate.Merge(g.Blobs(20_000, 20, 0.005), g.Uniform(3_050))).Scale(-74.05, 40.6, 0.3, 0.3)
+	filename := filepath.Join(t.TempDir(), "data.csv")
+	writeGeneratedCSV(filename, d, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	return filename
+}
 
 func TestFileSizeLoad(t *testing.T) {
-	_, points := readCSV("data.csv")
+	_, points := readCSV(testCSV(t))
 
 	if len(points) != NUMBER_OF_POINTS {
-		t.Error("File size is not 232050")
+		t.Error("File size is not 23050")
 	}
 }
 
 func TestBSPCount(t *testing.T) {
-	rect, points := readCSV("data.csv")
+	rect, points := readCSV(testCSV(t))
 
 	// Add all points to the tree
 	bsp := NewBSPTreeFromPoints(rect, &points)
@@ -47,3 +66,450 @@ func TestBSPCount(t *testing.T) {
 		t.Error("Size is not correct")
 	}
 }
+
+// Walks the tree and checks that every node size matches its contents
+func checkBSPSize(t *testing.T, q *BSPTree) int {
+	if q == nil {
+		return 0
+	}
+	if (q.left == nil) != (q.right == nil) {
+		t.Error("Node has a single child")
+	}
+	if q.left != nil && q.point != nil {
+		t.Error("Subdivided node still holds a point")
+	}
+	if q.left != nil && q.size == 0 {
+		t.Error("Empty subtree was not collapsed")
+	}
+	s := q.cnt + checkBSPSize(t, q.left) + checkBSPSize(t, q.right)
+	if s != q.size {
+		t.Errorf("Size is not correct: %d != %d", q.size, s)
+	}
+	return s
+}
+
+func TestBSPRemove(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	bsp := NewBSPTree(0, 0, 100, 100)
+	points := make([]Point, 500)
+	for i := range points {
+		points[i] = Point{rng.Float64() * 100, rng.Float64() * 100}
+		bsp.Insert(&points[i])
+	}
+	dup := points[0]
+	bsp.Insert(&dup) // Same place as points[0]
+	checkBSPSize(t, bsp)
+
+	if bsp.Remove(&Point{-1, -1}) {
+		t.Error("Removed a point that is not in the tree")
+	}
+
+	if !bsp.Remove(&points[0]) {
+		t.Error("Could not remove point")
+	}
+	if len(bsp.Query(Rect{points[0].x, points[0].y, 0, 0})) != 1 {
+		t.Error("Removing a duplicate removed every occurrence")
+	}
+	checkBSPSize(t, bsp)
+
+	for i := range points {
+		if !bsp.Remove(&points[i]) {
+			t.Errorf("Could not remove point %d", i)
+		}
+	}
+	checkBSPSize(t, bsp)
+
+	if bsp.size != 0 || bsp.left != nil || bsp.right != nil || bsp.point != nil {
+		t.Error("Tree is not empty after removing every point")
+	}
+	if len(bsp.Query(bsp.rect)) != 0 {
+		t.Error("Query on empty tree returned points")
+	}
+}
+
+func TestBSPMove(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 10, 10)
+	points := []Point{{1, 1}, {2, 2}, {8, 8}, {9, 9}}
+	for i := range points {
+		bsp.Insert(&points[i])
+	}
+
+	to := Point{5, 5}
+	if !bsp.Move(&points[0], &to) {
+		t.Error("Could not move point")
+	}
+	if bsp.Move(&points[0], &to) {
+		t.Error("Moved a point that is no longer in the tree")
+	}
+	checkBSPSize(t, bsp)
+
+	if len(bsp.Query(Rect{0, 0, 1.5, 1.5})) != 0 {
+		t.Error("Point is still at its old position")
+	}
+	if len(bsp.Query(Rect{4, 4, 2, 2})) != 1 {
+		t.Error("Point is not at its new position")
+	}
+	if bsp.size != len(points) {
+		t.Error("Move changed the tree size")
+	}
+}
+
+func TestBSPGrow(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 10, 10)
+	inside := []Point{{1, 1}, {5, 5}, {9, 9}}
+	outside := []Point{{-50, 5}, {500, 5}, {5, -7}, {5, 123}, {-1000, -1000}, {1e6, 1e6}, {10.5, 10.5}}
+	for i := range inside {
+		bsp.Insert(&inside[i])
+	}
+	for i := range outside {
+		bsp.Insert(&outside[i])
+		if !rectPointIntersect(bsp.rect, outside[i]) {
+			t.Errorf("Tree bounds %v do not contain %v", bsp.rect, outside[i])
+		}
+	}
+	checkBSPSize(t, bsp)
+
+	if bsp.size != len(inside)+len(outside) {
+		t.Error("Tree does not have the correct number of nodes")
+	}
+	if len(bsp.Query(bsp.rect)) != len(inside)+len(outside) {
+		t.Error("Query is not correct")
+	}
+
+	// Every point must still be reachable at its own position
+	for _, p := range append(inside, outside...) {
+		if len(bsp.Query(Rect{p.x, p.y, 0, 0})) != 1 {
+			t.Errorf("Point %v is not in the tree", p)
+		}
+	}
+	for _, p := range append(inside, outside...) {
+		p := p
+		if !bsp.Remove(&p) {
+			t.Errorf("Could not remove %v", p)
+		}
+	}
+	checkBSPSize(t, bsp)
+
+	// Growing an empty tree only stretches its bounds
+	empty := NewBSPTree(0, 0, 0, 0)
+	p := Point{-3, 4}
+	empty.Insert(&p)
+	if empty.left != nil || !rectPointIntersect(empty.rect, p) || empty.size != 1 {
+		t.Error("Empty tree did not grow correctly")
+	}
+}
+
+func TestBSPGrowEdge(t *testing.T) {
+	// (10, 5) is on the far edge of the old root, which becomes the edge between both children
+	bsp := NewBSPTree(0, 0, 10, 10)
+	points := []Point{{1, 1}, {10, 5}, {25, 5}}
+	for i := range points {
+		bsp.Insert(&points[i])
+	}
+	ids := bsp.IDs()
+
+	again := Point{10, 5}
+	bsp.Insert(&again)
+	if bsp.IDs() != ids || len(bsp.Query(Rect{10, 5, 0, 0})) != 1 {
+		t.Error("Point on the edge got a second location")
+	}
+	for i := 0; i < 2; i++ {
+		if !bsp.Remove(&Point{10, 5}) {
+			t.Fatal("Could not remove the point on the edge")
+		}
+	}
+	if bsp.Remove(&Point{10, 5}) || bsp.Size() != 2 {
+		t.Error("Removed a point that is no longer in the tree")
+	}
+	checkBSPSize(t, bsp)
+
+	// A new point on the edge can be moved away
+	edge := Point{10, 7}
+	bsp.Insert(&edge)
+	if !bsp.Move(&edge, &Point{3, 3}) || len(bsp.Query(Rect{10, 7, 0, 0})) != 0 {
+		t.Error("Could not move a new point on the edge")
+	}
+	checkBSPSize(t, bsp)
+}
+
+func TestBSPGrowEdgeQuery(t *testing.T) {
+	// The grown root rounds its bounds, (54.86347864497504, 0.66) was a rounding step outside of them
+	bsp := NewBSPTree(0, 0, 1, 1)
+	edge := Point{54.86347864497504, 0.6661087456996003}
+	points := []Point{edge, {43.695958588718, 1.3923776279467521}, {26.51058386151075, 2.001302416072528}, {-315.9096902792247, 116.89298331342707}}
+	for i := range points {
+		bsp.Insert(&points[i])
+	}
+	// Only touches the far edge of the point's child
+	if found := bsp.Query(Rect{edge.x, 0, 10, 1}); len(found) != 1 || *found[0].Point != edge {
+		t.Errorf("Query on the edge of the child found %v", found)
+	}
+
+	// Every point is found by a query of its exact location, whatever the child it went to
+	rng := rand.New(rand.NewSource(5))
+	bsp = NewBSPTree(0, 0, 1, 1)
+	for i := 0; i < 2_000; i++ {
+		p := Point{rng.Float64() * 100, rng.Float64() * 3}
+		if i%10 == 0 {
+			p = Point{rng.Float64()*1000 - 500, rng.Float64()*1000 - 500}
+		}
+		bsp.Insert(&p)
+		points = append(points, p)
+	}
+	for _, p := range points[4:] {
+		if len(bsp.Query(Rect{p.x, p.y, 0, 0})) != 1 {
+			t.Fatalf("Query at %v did not find it", p)
+		}
+	}
+}
+
+func TestBSPQueryFlatTree(t *testing.T) {
+	// Every point on the same vertical line, so the tree has no width
+	bsp := NewBSPTree(0, 0, 0, 10)
+	points := []Point{{0, 0}, {0, 3}, {0, 7}, {0, 10}, {4, 5}, {1, 5}, {-2, 1}}
+	for i := range points {
+		bsp.Insert(&points[i])
+	}
+	checkBSPSize(t, bsp)
+
+	for _, p := range points {
+		if len(bsp.Query(Rect{p.x, p.y, 0, 0})) != 1 {
+			t.Errorf("Point %v is not in the tree", p)
+		}
+	}
+	if len(bsp.Query(Rect{0.5, 0, 4, 10})) != 2 {
+		t.Error("Query is not correct")
+	}
+}
+
+func TestBSPNearest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bsp := NewBSPTree(0, 0, 100, 100)
+	points := make([]Point, 1000)
+	for i := range points {
+		points[i] = Point{float64(rng.Intn(100)), float64(rng.Intn(100))} // Duplicates on purpose
+		bsp.Insert(&points[i])
+	}
+
+	for _, k := range []float64{1, 5, 50, 2000} {
+		p := Point{rng.Float64() * 120, rng.Float64() * 120}
+		nearest := bsp.Nearest(p, k)
+
+		cnt := 0
+		for i, n := range nearest {
+			cnt += n.cnt
+			if i > 0 && n.Point.Distance(p) < nearest[i-1].Point.Distance(p) {
+				t.Error("Points are not sorted by distance")
+			}
+		}
+		if float64(cnt) < k && cnt != len(points) {
+			t.Errorf("Found %d points instead of %v", cnt, k)
+		}
+
+		// No point outside of the result can be closer than the last one
+		last := nearest[len(nearest)-1].Point.Distance(p)
+		closer := 0
+		for _, q := range points {
+			if q.Distance(p) < last {
+				closer++
+			}
+		}
+		if float64(closer) >= k {
+			t.Errorf("The %v nearest points are not the closest ones", k)
+		}
+	}
+}
+
+func TestBSPWeights(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 10, 10)
+	points := []Point{{1, 1}, {1, 1}, {2, 2}, {8, 8}}
+	weights := []float64{2, 0.5, 3, 4}
+	for i := range points {
+		bsp.InsertWeighted(&points[i], weights[i])
+	}
+
+	total := 0.0
+	for _, p := range bsp.Query(bsp.rect) {
+		total += p.weight
+		if *p.Point == (Point{1, 1}) && (p.cnt != 2 || p.weight != 2.5) {
+			t.Errorf("Duplicates were not weighted correctly: %v", p)
+		}
+	}
+	if total != 9.5 {
+		t.Errorf("Total weight is %f instead of 9.5", total)
+	}
+
+	bsp.RemoveWeighted(&points[0], 2)
+	bsp.RemoveWeighted(&points[2], 3)
+	checkBSPSize(t, bsp)
+	remaining := bsp.Query(Rect{0, 0, 3, 3})
+	if len(remaining) != 1 || remaining[0].weight != 0.5 {
+		t.Errorf("Weights were not removed correctly: %v", remaining)
+	}
+}
+
+// Dataset sizes of the benchmarks
+var benchmarkSizes = []int{1_000, 10_000, 100_000}
+
+var benchmarkDatasets = make(map[int][]Point)
+
+// Blobs with 10% of noise in the unit square, always the same points for the same size
+func benchmarkPoints(n int) []Point {
+	if points, ok := benchmarkDatasets[n]; ok {
+		return points
+	}
+	g := generate.New(1)
+	d := g.Shuffle(generate.Merge(g.Blobs(n-n/10, 20, 0.02), g.Uniform(n/10)))
+	points := make([]Point, len(d.Points))
+	for i, p := range d.Points {
+		points[i] = Point{p.X, p.Y}
+	}
+	benchmarkDatasets[n] = points
+	return points
+}
+
+// Epsilon that keeps about the same number of neighbors whatever the size
+func benchmarkEpsilon(n int) float64 {
+	return 0.2 / math.Sqrt(float64(n))
+}
+
+func BenchmarkNewBSPTreeFromPoints(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		rect := pointsBounds(points)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewBSPTreeFromPoints(rect, &points)
+			}
+		})
+	}
+}
+
+func BenchmarkBSPQuery(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+		epsilon := benchmarkEpsilon(n)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				bsp.Query(Rect{p.x - epsilon, p.y - epsilon, epsilon * 2, epsilon * 2})
+			}
+		})
+	}
+}
+
+func BenchmarkBSPQueryAsync(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+		epsilon := benchmarkEpsilon(n)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				for range bsp.QueryAsync(Rect{p.x - epsilon, p.y - epsilon, epsilon * 2, epsilon * 2}) {
+				}
+			}
+		})
+	}
+}
+
+func TestBSPWeightWithin(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 10, 10)
+	points := []Point{{1, 1}, {1, 1}, {2, 1}, {2, 2}, {8, 8}}
+	weights := []float64{2, 0.5, 3, 1, 4}
+	for i := range points {
+		bsp.InsertWeighted(&points[i], weights[i])
+	}
+
+	// The corners of the square around the point are not within the radius
+	if w := bsp.WeightWithin(Point{1, 1}, 1, 100); w != 5.5 {
+		t.Errorf("Weight within 1 of (1, 1) is %f instead of 5.5", w)
+	}
+	if w := bsp.WeightWithin(Point{5, 5}, 1, 100); w != 0 {
+		t.Errorf("Weight within 1 of (5, 5) is %f instead of 0", w)
+	}
+	// Stops early, but never below the limit
+	if w := bsp.WeightWithin(Point{1, 1}, 20, 3); w < 3 || w > 10.5 {
+		t.Errorf("Weight within 20 of (1, 1) with a limit of 3 is %f", w)
+	}
+}
+
+func TestBSPIDs(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 1, 1)
+	points := []Point{{0.5, 0.5}, {0.2, 0.2}, {0.5, 0.5}, {3, -2}, {0.9, 0.1}}
+	for i := range points {
+		bsp.Insert(&points[i]) // Subdivides and grows the tree
+	}
+	if bsp.IDs() != 4 {
+		t.Errorf("%d ids for 4 locations", bsp.IDs())
+	}
+
+	ids := make(map[Point]int)
+	for _, p := range bsp.Query(bsp.rect) {
+		if _, ok := ids[*p.Point]; ok || p.id < 0 || p.id >= bsp.IDs() {
+			t.Errorf("Location %v has a duplicate or out of range id %d", *p.Point, p.id)
+		}
+		ids[*p.Point] = p.id
+	}
+	if ids[points[0]] != 0 || ids[points[1]] != 1 || ids[points[3]] != 2 || ids[points[4]] != 3 {
+		t.Errorf("Ids are not in insertion order: %v", ids)
+	}
+
+	// Ids stay with their location when the tree collapses
+	bsp.Remove(&points[1])
+	bsp.Remove(&points[4])
+	for _, p := range bsp.Query(bsp.rect) {
+		if p.id != ids[*p.Point] {
+			t.Errorf("Location %v changed id from %d to %d", *p.Point, ids[*p.Point], p.id)
+		}
+	}
+}
+
+func TestBSPRandomUpdates(t *testing.T) {
+	// The root grows many times, so points end up on the edges of rects that are rounded differently
+	for seed := int64(0); seed < 100; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		bsp := NewBSPTree(0, 0, 1, 1)
+		inserted := []Point{}
+		for i := 0; i < 500; i++ {
+			switch op := rng.Intn(10); {
+			case op < 5 || len(inserted) == 0:
+				p := Point{rng.Float64() * 100, rng.Float64() * 3}
+				if k := rng.Intn(3); k == 0 && len(inserted) > 0 { // Duplicate
+					p = inserted[rng.Intn(len(inserted))]
+				} else if k == 1 { // Wide range, grows the root
+					p = Point{rng.Float64()*1000 - 500, rng.Float64()*1000 - 500}
+				}
+				bsp.Insert(&p)
+				inserted = append(inserted, p)
+			case op < 7:
+				j := rng.Intn(len(inserted))
+				p := inserted[j]
+				if !bsp.holds(&p) || !bsp.Remove(&p) {
+					t.Fatalf("Seed %d, step %d: could not remove %v", seed, i, p)
+				}
+				inserted = append(inserted[:j], inserted[j+1:]...)
+			default:
+				j := rng.Intn(len(inserted))
+				from, to := inserted[j], Point{rng.Float64()*2000 - 1000, rng.Float64() * 3}
+				if !bsp.Move(&from, &to) {
+					t.Fatalf("Seed %d, step %d: could not move %v", seed, i, from)
+				}
+				inserted[j] = to
+			}
+		}
+		checkBSPSize(t, bsp)
+
+		// Every point is still in the tree, once per insert
+		for _, p := range inserted {
+			p := p
+			if !bsp.Remove(&p) {
+				t.Fatalf("Seed %d: %v can't be removed", seed, p)
+			}
+		}
+		if bsp.Size() != 0 {
+			t.Errorf("Seed %d: tree holds %d points after removing everything", seed, bsp.Size())
+		}
+	}
+}
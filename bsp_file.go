@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+// Binary format of a saved BSPTree (little endian):
+//
+//	magic "DBSCNBSP", version uint32, number of nodes uint64, number of locations uint64, ids uint64,
+//	  bounds of the root (4 float64)
+//	every node in pre-order: flags byte,
+//	  then if it holds a location (bspFilePoint): x, y float64, cnt uvarint, weight float64 (unless
+//	  bspFileUnitWeight: the weight is cnt), id uvarint
+//	  then if it has children (bspFileChildren): the bounds of both children (8 float64), unless
+//	  bspFileHalves: they are the halves Subdivide cuts
+//	CRC-32 (Castagnoli) of everything before it, uint32
+//
+// The sizes of the nodes are not saved, they add up from the counts when the tree is loaded.
+const (
+	bspFileMagic      = "DBSCNBSP"
+	bspFileVersion    = 1
+	bspFileHeader     = len(bspFileMagic) + 4 + 3*8 + 4*8
+	bspFilePoint      = 1 << 0
+	bspFileChildren   = 1 << 1
+	bspFileHalves     = 1 << 2
+	bspFileUnitWeight = 1 << 3
+)
+
+var errFileChecksum = errors.New("corrupted file (checksum mismatch)")
+
+// Checksum of the binary files (trees and models)
+var binaryFileTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Writes the tree in the binary format, points at the same location are saved once
+func (q *BSPTree) WriteTo(w io.Writer) (int64, error) {
+	nodes, locations := 0, 0
+	q.walkNodes(func(n *BSPTree) {
+		nodes++
+		if n.point != nil {
+			locations++
+		}
+	})
+
+	hash := crc32.New(binaryFileTable)
+	out := bufio.NewWriter(io.MultiWriter(w, hash))
+	buf := make([]byte, 0, bspFileHeader)
+	buf = append(buf, bspFileMagic...)
+	buf = appendUint32(buf, bspFileVersion)
+	buf = appendUint64(buf, uint64(nodes))
+	buf = appendUint64(buf, uint64(locations))
+	buf = appendUint64(buf, uint64(q.IDs()))
+	buf = appendRect(buf, q.rect)
+	written, _ := out.Write(buf)
+
+	q.walkNodes(func(n *BSPTree) {
+		buf = buf[:0]
+		flags := byte(0)
+		if n.point != nil {
+			flags |= bspFilePoint
+			if n.weight == float64(n.cnt) {
+				flags |= bspFileUnitWeight
+			}
+		}
+		if n.left != nil && n.right != nil {
+			flags |= bspFileChildren
+			if left, right := n.rect.halves(); left == n.left.rect && right == n.right.rect {
+				flags |= bspFileHalves
+			}
+		}
+		buf = append(buf, flags)
+		if n.point != nil {
+			buf = appendUint64(buf, math.Float64bits(n.point.x))
+			buf = appendUint64(buf, math.Float64bits(n.point.y))
+			buf = appendUvarint(buf, uint64(n.cnt))
+			if flags&bspFileUnitWeight == 0 {
+				buf = appendUint64(buf, math.Float64bits(n.weight))
+			}
+			buf = appendUvarint(buf, uint64(n.id))
+		}
+		if flags&bspFileChildren != 0 && flags&bspFileHalves == 0 {
+			buf = appendRect(buf, n.left.rect)
+			buf = appendRect(buf, n.right.rect)
+		}
+		k, _ := out.Write(buf)
+		written += k
+	})
+	if err := out.Flush(); err != nil {
+		return int64(written), err
+	}
+
+	k, err := w.Write(appendUint32(nil, hash.Sum32()))
+	return int64(written + k), err
+}
+
+func appendRect(buf []byte, r Rect) []byte {
+	for _, v := range [...]float64{r.x, r.y, r.w, r.h} {
+		buf = appendUint64(buf, math.Float64bits(v))
+	}
+	return buf
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+// Calls f on every node of the tree, the node first, then the left and the right subtrees
+func (q *BSPTree) walkNodes(f func(n *BSPTree)) {
+	if q == nil {
+		return
+	}
+	f(q)
+	if q.left != nil && q.right != nil {
+		q.left.walkNodes(f)
+		q.right.walkNodes(f)
+	}
+}
+
+// Reads a tree written by WriteTo
+// The points of the tree are allocated at once, the checksum is checked before anything is decoded.
+func ReadBSPTree(r io.Reader) (*BSPTree, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := checkBinaryFile(data, bspFileMagic, bspFileVersion, bspFileHeader, "BSP tree")
+	if err != nil {
+		return nil, err
+	}
+
+	d := bspFileDecoder{binaryDecoder: binaryDecoder{data: body, pos: len(bspFileMagic) + 4}}
+	nodes, locations, ids := d.uint64(), d.uint64(), d.uint64()
+	if locations > nodes || nodes > uint64(len(body)) || ids > math.MaxInt32 { // Every node takes more than a byte
+		return nil, errors.New("invalid BSP tree file header")
+	}
+	d.nodes = int(nodes)
+	d.points = make([]Point, 0, locations)
+	d.ids = new(int)
+	*d.ids = int(ids)
+
+	tree := d.node(d.rect())
+	if d.err == nil && d.pos != len(body) {
+		d.err = errors.New("unexpected data after the BSP tree")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return tree, nil
+}
+
+// Checks the magic, the version and the checksum of a binary file (of the given kind), returns it without the checksum
+func checkBinaryFile(data []byte, magic string, version uint32, header int, kind string) ([]byte, error) {
+	if len(data) < len(magic) || !bytes.Equal(data[:len(magic)], []byte(magic)) {
+		return nil, fmt.Errorf("not a %s file", kind)
+	}
+	if len(data) < header+4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if v := binary.LittleEndian.Uint32(data[len(magic):]); v != version {
+		return nil, fmt.Errorf("unsupported %s file version %d (expected %d)", kind, v, version)
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, binaryFileTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, errFileChecksum
+	}
+	return body, nil
+}
+
+// Reads the values of a binary file, stops at the first error
+type binaryDecoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// Reads the nodes of a tree file
+type bspFileDecoder struct {
+	binaryDecoder
+	nodes  int     // Nodes left to read
+	points []Point // Backing array of the points of the tree
+	ids    *int
+}
+
+func (d *binaryDecoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos+8 > len(d.data) {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.data[d.pos:])
+	d.pos += 8
+	return v
+}
+
+func (d *binaryDecoder) float64() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *binaryDecoder) rect() Rect {
+	return Rect{d.float64(), d.float64(), d.float64(), d.float64()}
+}
+
+func (d *binaryDecoder) uvarint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, k := binary.Uvarint(d.data[d.pos:])
+	if k <= 0 || v > math.MaxInt32 {
+		d.err = errors.New("invalid count in binary file")
+		return 0
+	}
+	d.pos += k
+	return int(v)
+}
+
+// Reads a node and its subtrees, the bounds of a node are saved with its parent
+func (d *bspFileDecoder) node(rect Rect) *BSPTree {
+	if d.err != nil {
+		return nil
+	}
+	if d.nodes == 0 || d.pos >= len(d.data) {
+		d.err = errors.New("more BSP tree nodes than in the header")
+		return nil
+	}
+	d.nodes--
+	flags := d.data[d.pos]
+	d.pos++
+
+	q := &BSPTree{rect: rect, ids: d.ids}
+	if flags&bspFilePoint != 0 {
+		if len(d.points) == cap(d.points) {
+			d.err = errors.New("more BSP tree locations than in the header")
+			return nil
+		}
+		d.points = append(d.points, Point{d.float64(), d.float64()})
+		q.point = &d.points[len(d.points)-1]
+		q.cnt = d.uvarint()
+		q.weight = float64(q.cnt)
+		if flags&bspFileUnitWeight == 0 {
+			q.weight = d.float64()
+		}
+		q.id = d.uvarint()
+		q.size = q.cnt
+		if q.id >= *d.ids {
+			d.err = errors.New("invalid location id in BSP tree file")
+		}
+	}
+	if flags&bspFileChildren != 0 {
+		left, right := rect.halves()
+		if flags&bspFileHalves == 0 {
+			left, right = d.rect(), d.rect()
+		}
+		q.left = d.node(left)
+		q.right = d.node(right)
+		if d.err != nil {
+			return nil
+		}
+		q.size += q.left.size + q.right.size
+	}
+	return q
+}
+
+// Saves the tree to a file
+func SaveBSPTree(filename string, bsp *BSPTree) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := bsp.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Loads a tree saved by SaveBSPTree
+func LoadBSPTree(filename string) (*BSPTree, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	bsp, err := ReadBSPTree(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return bsp, nil
+}
+
+// Does the file start like a saved tree? Used to accept a tree wherever a CSV file is read.
+func isBSPTreeFile(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	magic := make([]byte, len(bspFileMagic))
+	_, err = io.ReadFull(file, magic)
+	return err == nil && string(magic) == bspFileMagic
+}
+
+// Reads the input of a clustering as a tree: a tree saved by the index command is loaded as is,
+// a CSV file is read and indexed. Exits if a saved tree can't be loaded.
+func readInputTree(filename string, weightColumn int, out io.Writer) *BSPTree {
+	if isBSPTreeFile(filename) {
+		fmt.Fprintln(out, "Loading BSP tree...")
+		if weightColumn >= 0 {
+			fmt.Fprintln(os.Stderr, "Warning: the weights saved in the tree are used, -weightColumn is ignored")
+		}
+		bsp, err := LoadBSPTree(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return bsp
+	}
+
+	// Read the CSV file and return a list of points and a bounding box
+	fmt.Fprintln(out, "Reading file...")
+	rect, points, weights := readWeightedCSV(filename, weightColumn)
+	// Starts a new binary space partition for speed-up querying
+	fmt.Fprintln(out, "Building BSP tree...")
+	return NewBSPTreeFromWeightedPoints(rect, &points, weights)
+}
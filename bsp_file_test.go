@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// Tree with weights, repeated locations, grown bounds and removed points
+func savedTestTree() *BSPTree {
+	rng := rand.New(rand.NewSource(1))
+	points, weights := randomDataset(rng, 3_000)
+	bsp := NewBSPTreeFromWeightedPoints(Rect{40, 40, 10, 10}, &points, weights) // Grows to fit the points
+	for i := 0; i < 200; i++ {
+		bsp.RemoveWeighted(&points[i], weights[i])
+	}
+	return bsp
+}
+
+func TestBSPTreeFileRoundTrip(t *testing.T) {
+	bsp := savedTestTree()
+	var buf bytes.Buffer
+	n, err := bsp.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo wrote %d bytes (%d in the buffer): %v", n, buf.Len(), err)
+	}
+
+	loaded, err := ReadBSPTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Size() != bsp.Size() || loaded.IDs() != bsp.IDs() || loaded.rect != bsp.rect {
+		t.Errorf("Loaded tree has %d points, %d ids and bounds %v instead of %d, %d and %v",
+			loaded.Size(), loaded.IDs(), loaded.rect, bsp.Size(), bsp.IDs(), bsp.rect)
+	}
+	text := func(points []BSPTreePoint) string {
+		s := ""
+		for _, p := range points {
+			s += fmt.Sprint(*p.Point, p.cnt, p.weight, p.id, " ")
+		}
+		return s
+	}
+	if text(loaded.Query(loaded.rect)) != text(bsp.Query(bsp.rect)) {
+		t.Error("Loaded tree does not hold the same locations in the same order")
+	}
+	rects := []Rect{}
+	bsp.walkNodes(func(n *BSPTree) { rects = append(rects, n.rect) })
+	loaded.walkNodes(func(n *BSPTree) {
+		if len(rects) == 0 || n.rect != rects[0] {
+			t.Fatalf("Loaded tree does not have the same nodes")
+		}
+		rects = rects[1:]
+	})
+
+	// Same clusters, and the tree keeps working
+	expected, _ := dbscanClusters(context.Background(), bsp, nil, 2, 4, 100, 2, nil)
+	clusters, _ := dbscanClusters(context.Background(), loaded, nil, 2, 4, 100, 2, nil)
+	if len(clusters) != len(expected) {
+		t.Errorf("Loaded tree has %d clusters instead of %d", len(clusters), len(expected))
+	}
+	p := Point{-20, 130}
+	loaded.Insert(&p)
+	if !loaded.Remove(&p) || loaded.Size() != bsp.Size() {
+		t.Error("Loaded tree can't be updated")
+	}
+}
+
+func TestBSPTreeFileEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	NewBSPTree(0, 0, 1, 1).WriteTo(&buf)
+	loaded, err := ReadBSPTree(&buf)
+	if err != nil || loaded.Size() != 0 || loaded.rect != (Rect{0, 0, 1, 1}) {
+		t.Errorf("Empty tree loads as %v: %v", loaded, err)
+	}
+}
+
+func TestBSPTreeFileErrors(t *testing.T) {
+	var buf bytes.Buffer
+	savedTestTree().WriteTo(&buf)
+	data := buf.Bytes()
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 0x10
+	if _, err := ReadBSPTree(bytes.NewReader(corrupted)); !errors.Is(err, errFileChecksum) {
+		t.Errorf("Corrupted file gives %v", err)
+	}
+
+	version := append([]byte{}, data...)
+	version[len(bspFileMagic)] = 9
+	if _, err := ReadBSPTree(bytes.NewReader(version)); err == nil {
+		t.Error("Unknown version is accepted")
+	}
+
+	for _, bad := range [][]byte{nil, []byte("x,y\n1,2\n"), data[:len(data)-10], data[:bspFileHeader]} {
+		if _, err := ReadBSPTree(bytes.NewReader(bad)); err == nil {
+			t.Errorf("%d bytes of invalid data are accepted", len(bad))
+		}
+	}
+}
+
+func TestBSPTreeFileSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.bsp")
+	bsp := savedTestTree()
+	if err := SaveBSPTree(filename, bsp); err != nil {
+		t.Fatal(err)
+	}
+	if !isBSPTreeFile(filename) || isBSPTreeFile(testCSV(t)) || isBSPTreeFile(filepath.Join(dir, "missing")) {
+		t.Error("Saved trees are not told apart from the other files")
+	}
+	loaded, err := LoadBSPTree(filename)
+	if err != nil || loaded.Size() != bsp.Size() {
+		t.Errorf("Loaded %v: %v", loaded, err)
+	}
+	if _, err := LoadBSPTree(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Loading a missing file gives no error")
+	}
+}
+
+func BenchmarkLoadBSPTree(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		var buf bytes.Buffer
+		NewBSPTreeFromPoints(pointsBounds(points), &points).WriteTo(&buf)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			b.SetBytes(int64(buf.Len()))
+			for i := 0; i < b.N; i++ {
+				ReadBSPTree(bytes.NewReader(buf.Bytes()))
+			}
+		})
+	}
+}
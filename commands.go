@@ -0,0 +1,577 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"dbscan/evaluate"
+	"dbscan/generate"
+)
+
+// Subcommands, running without one is the classic DBSCAN from main
+var commands = map[string]func(args []string){
+	"window":   windowCommand,
+	"stdbscan": stdbscanCommand,
+	"ndbscan":  ndbscanCommand,
+	"hdbscan":  hdbscanCommand,
+	"optics":   opticsCommand,
+	"extract":  extractCommand,
+	"sweep":    sweepCommand,
+	"evaluate": evaluateCommand,
+	"compare":  compareCommand,
+	"generate": generateCommand,
+	"index":    indexCommand,
+	"predict":  predictCommand,
+}
+
+// One line description of each subcommand
+var commandHelp = map[string]string{
+	"window":   "cluster timestamped points over a sliding time window",
+	"stdbscan": "spatio-temporal clustering with separate space and time epsilons",
+	"ndbscan":  "cluster any number of CSV columns as N-dimensional points",
+	"hdbscan":  "hierarchical clustering for data of varying density (no epsilon)",
+	"optics":   "export the OPTICS ordering (reachability plot) of the points",
+	"extract":  "extract clusters from an OPTICS ordering at any epsilon or with xi",
+	"sweep":    "run DBSCAN over a grid of epsilon and minPts values and compare them",
+	"evaluate": "score the clusters of a points.csv output (silhouette, Davies-Bouldin, DBCV...)",
+	"compare":  "compare two points.csv outputs (ARI, NMI, cluster matching)",
+	"generate": "write a synthetic dataset with known clusters (blobs, moons, rings...)",
+	"index":    "build the BSP tree of a CSV file once and save it, to load it faster on the next runs",
+	"predict":  "label new points with the clusters of a model saved by ./dbscan -model",
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Counts the clusters and noise points of a labeling (0 is noise)
+func labelSummary(labels []int) (clusters int, noise int) {
+	for _, label := range labels {
+		if label > clusters {
+			clusters = label
+		}
+		if label == 0 {
+			noise++
+		}
+	}
+	return clusters, noise
+}
+
+// Parses a comma separated list of non negative integers, exits on invalid values
+func parseInts(list string, name string) []int {
+	values := []int{}
+	for _, v := range strings.Split(list, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || value < 0 {
+			fmt.Fprintln(os.Stderr, "Error: invalid", name, v)
+			os.Exit(2)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// Parses a comma separated list of positive numbers, exits on invalid values
+func parseFloats(list string, name string) []float64 {
+	values := []float64{}
+	for _, v := range strings.Split(list, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || value <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: invalid", name, v)
+			os.Exit(2)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// Creates the flag set of a subcommand, with the usage line printed on -h
+func newCommandFlags(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:   ./dbscan", name, usage)
+		fmt.Fprintln(flags.Output(), "         "+commandHelp[name])
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Sliding time window clustering
+func windowCommand(args []string) {
+	flags := newCommandFlags("window", "[flags] <input_file>")
+	timeColumn := flags.Int("timeColumn", 1, "index of the CSV column holding the time")
+	layout := flags.String("layout", "2006-01-02 15:04:05", "time layout (Go reference time)")
+	window := flags.Duration("window", 15*time.Minute, "length of each window")
+	step := flags.Duration("step", 5*time.Minute, "time between the start of two windows")
+	epsilon := flags.Float64("epsilon", 0.0003, "neighborhood radius")
+	minPts := flags.Int("minPts", 5, "minimum cluster size")
+	maxJobSize := flags.Int("maxJobSize", 0, "maximum number of points in a single job (0 to let the scheduler size the jobs)")
+	threadN := flags.Int("threadN", runtime.NumCPU(), "number of workers")
+	out := flags.String("out", "./windows.csv", "clusters of every window")
+	lineageOut := flags.String("lineage", "./lineage.csv", "links between clusters of consecutive windows")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *window <= 0 || *step <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	_, points := readTimedCSV(flags.Arg(0), *timeColumn, *layout)
+	fmt.Println("Clustering", len(points), "points...")
+	windows := clusterWindows(points, *window, *step, *epsilon, *minPts, *maxJobSize, *threadN)
+	for i, w := range windows {
+		fmt.Printf("Window %d [%s, %s): %d points, %d clusters\n",
+			i, w.start.Format(time.RFC3339), w.end.Format(time.RFC3339), w.points, len(w.clusters))
+	}
+
+	fmt.Println("Saving results...")
+	writeWindowCSV(*out, windows)
+	writeLineageCSV(*lineageOut, windowLineage(windows))
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Spatio-temporal DBSCAN
+func stdbscanCommand(args []string) {
+	flags := newCommandFlags("stdbscan", "[flags] <input_file>")
+	timeColumn := flags.Int("timeColumn", 1, "index of the CSV column holding the time")
+	layout := flags.String("layout", "2006-01-02 15:04:05", "time layout (Go reference time)")
+	epsilon := flags.Float64("epsilon", 0.0003, "neighborhood radius in space")
+	epsilonTime := flags.Duration("epsilonTime", 10*time.Minute, "neighborhood radius in time")
+	minPts := flags.Int("minPts", 5, "minimum number of neighbors of a core point")
+	out := flags.String("out", "./clusters.csv", "clusters output")
+	pointsOut := flags.String("points", "./points.csv", "clustered points output")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	_, points := readTimedCSV(flags.Arg(0), *timeColumn, *layout)
+	fmt.Println("Starting ST-DBSCAN on", len(points), "points...")
+	labels := stdbscan(points, *epsilon, *epsilonTime, *minPts)
+
+	clusters, noise := labelSummary(labels)
+	fmt.Println("Clusters found:", clusters, "| Noise points:", noise, "| ΔT:", time.Since(startT))
+
+	fmt.Println("Saving results...")
+	writeSTClusters(*out, points, labels)
+	writeSTClusterPoints(*pointsOut, points, labels)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// DBSCAN on N-dimensional points
+func ndbscanCommand(args []string) {
+	flags := newCommandFlags("ndbscan", "[flags] <input_file>")
+	columnList := flags.String("columns", "8,9", "comma separated indices of the CSV columns to cluster on")
+	epsilon := flags.Float64("epsilon", 0.0003, "neighborhood radius")
+	minPts := flags.Int("minPts", 5, "minimum number of neighbors of a core point")
+	threadN := flags.Int("threadN", runtime.NumCPU(), "number of workers")
+	out := flags.String("points", "./points.csv", "clustered points output")
+	flags.Parse(args)
+
+	columns := parseInts(*columnList, "column")
+	if flags.NArg() != 1 || len(columns) == 0 || *threadN < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	names, points := readCSVN(flags.Arg(0), columns)
+	fmt.Println("Starting DBSCAN on", len(points), "points with", len(columns), "dimensions...")
+	labels := dbscanN(points, nil, *epsilon, *minPts, *threadN)
+
+	clusters, noise := labelSummary(labels)
+	fmt.Println("Clusters found:", clusters, "| Noise points:", noise, "| ΔT:", time.Since(startT))
+
+	fmt.Println("Saving results...")
+	writeNClusterPoints(*out, names, points, labels)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Hierarchical DBSCAN
+func hdbscanCommand(args []string) {
+	flags := newCommandFlags("hdbscan", "[flags] <input_file>")
+	minPts := flags.Int("minPts", 5, "neighbors used for the core distance")
+	minClusterSize := flags.Int("minClusterSize", 5, "minimum cluster size")
+	threadN := flags.Int("threadN", runtime.NumCPU(), "number of workers")
+	weightColumn := flags.Int("weightColumn", -1, "index of the CSV column holding the weight of each point")
+	out := flags.String("points", "./points.csv", "clustered points output")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *minPts < 1 || *minClusterSize < 2 || *threadN < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	rect, points, weights := readWeightedCSV(flags.Arg(0), *weightColumn)
+	fmt.Println("Building BSP tree...")
+	bsp := NewBSPTreeFromWeightedPoints(rect, &points, weights)
+	fmt.Println("Starting HDBSCAN...")
+	res := hdbscan(bsp, *minPts, *minClusterSize, *threadN)
+
+	clusters, noise := labelSummary(res.labels)
+	fmt.Println("Clusters found:", clusters, "| Noise locations:", noise, "| ΔT:", time.Since(startT))
+
+	fmt.Println("Saving results...")
+	writeHDBSCANPoints(*out, res)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// OPTICS ordering, optionally extracting clusters right away
+func opticsCommand(args []string) {
+	flags := newCommandFlags("optics", "[flags] <input_file>")
+	maxEpsilon := flags.Float64("maxEpsilon", 0.003, "largest neighborhood radius (inf for no limit)")
+	minPts := flags.Int("minPts", 5, "minimum number of neighbors of a core point")
+	out := flags.String("out", "./reachability.csv", "ordering output")
+	epsilon := flags.Float64("epsilon", 0, "also extract the DBSCAN clusters at this epsilon")
+	xi := flags.Float64("xi", 0, "also extract the clusters with the xi method")
+	minClusterSize := flags.Int("minClusterSize", 5, "minimum cluster size for the xi method")
+	weightColumn := flags.Int("weightColumn", -1, "index of the CSV column holding the weight of each point")
+	pointsOut := flags.String("points", "./points.csv", "clustered points output")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *maxEpsilon <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	rect, points, weights := readWeightedCSV(flags.Arg(0), *weightColumn)
+	fmt.Println("Building BSP tree...")
+	bsp := NewBSPTreeFromWeightedPoints(rect, &points, weights)
+	fmt.Println("Starting OPTICS...")
+	res := optics(bsp, *maxEpsilon, *minPts)
+	fmt.Println("Ordered", len(res.points), "locations | ΔT:", time.Since(startT))
+
+	fmt.Println("Saving results...")
+	writeOPTICSCSV(*out, res)
+	if *epsilon > 0 || *xi > 0 {
+		extract(res, *epsilon, *xi, *minPts, *minClusterSize, *pointsOut)
+	}
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Clusters from an existing OPTICS ordering
+func extractCommand(args []string) {
+	flags := newCommandFlags("extract", "[-epsilon <epsilon> | -xi <xi>] [flags] <reachability_file>")
+	epsilon := flags.Float64("epsilon", 0, "extract the DBSCAN clusters at this epsilon")
+	xi := flags.Float64("xi", 0, "extract the clusters with the xi method")
+	minPts := flags.Int("minPts", 5, "minPts used by the OPTICS run")
+	minClusterSize := flags.Int("minClusterSize", 5, "minimum cluster size for the xi method")
+	pointsOut := flags.String("points", "./points.csv", "clustered points output")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || (*epsilon > 0) == (*xi > 0) || *xi >= 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	res := readOPTICSCSV(flags.Arg(0))
+	extract(res, *epsilon, *xi, *minPts, *minClusterSize, *pointsOut)
+}
+
+func extract(res OPTICSResult, epsilon float64, xi float64, minPts int, minClusterSize int, filename string) {
+	var labels []int
+	if xi > 0 {
+		labels = res.ExtractXi(xi, minPts, minClusterSize)
+	} else {
+		labels = res.ExtractDBSCAN(epsilon)
+	}
+	clusters, noise := labelSummary(labels)
+	fmt.Println("Clusters found:", clusters, "| Noise locations:", noise)
+	writeLabeledPoints(filename, res.points, labels)
+}
+
+// Parameter sweep over epsilon and minPts, building the tree only once
+func sweepCommand(args []string) {
+	flags := newCommandFlags("sweep", "[flags] <input_file or index_file>")
+	epsilonList := flags.String("epsilons", "0.0001,0.0002,0.0003,0.0005", "comma separated epsilon values")
+	minPtsList := flags.String("minPts", "3,5,10", "comma separated minPts values")
+	maxJobSize := flags.Int("maxJobSize", 0, "maximum number of points in a single job (0 to let the scheduler size the jobs)")
+	threadN := flags.Int("threadN", runtime.NumCPU(), "number of workers")
+	weightColumn := flags.Int("weightColumn", -1, "index of the CSV column holding the weight of each point")
+	sample := flags.Int("sample", 2_000, "number of locations the silhouette is computed on (0 for all of them)")
+	out := flags.String("out", "./sweep.csv", "summary output")
+	flags.Parse(args)
+
+	epsilons := parseFloats(*epsilonList, "epsilon")
+	minPts := parseInts(*minPtsList, "minPts")
+	if flags.NArg() != 1 || *maxJobSize < 0 || *threadN < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	bsp := readInputTree(flags.Arg(0), *weightColumn, os.Stdout)
+	fmt.Println("Running", len(epsilons)*len(minPts), "clusterings...")
+	results := sweep(bsp, epsilons, minPts, *maxJobSize, *threadN, *sample)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Epsilon\tMinPts\tClusters\tNoise\tLargest\tRuntime\tSilhouette\t")
+	for _, res := range results {
+		fmt.Fprintf(w, "%g\t%d\t%d\t%.1f%%\t%d\t%v\t%.3f\t\n", res.epsilon, res.minPts, res.clusters,
+			res.noise*100, res.largest, res.runtime.Round(time.Millisecond), res.silhouette)
+	}
+	w.Flush()
+	fmt.Println()
+
+	fmt.Println("Saving results...")
+	writeSweepCSV(*out, results)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Quality scores of an existing clustering
+func evaluateCommand(args []string) {
+	flags := newCommandFlags("evaluate", "[flags] <points_file>")
+	labelColumn := flags.Int("labelColumn", 0, "index of the CSV column holding the cluster (0 is noise)")
+	columnList := flags.String("columns", "1,2", "comma separated indices of the CSV columns holding the coordinates")
+	total := flags.Int("total", 0, "number of points that were clustered, the missing ones count as noise for DBCV")
+	sample := flags.Int("sample", 2_000, "number of points the silhouette and DBCV are computed on (0 for all of them)")
+	threadN := flags.Int("threadN", runtime.NumCPU(), "number of workers")
+	flags.Parse(args)
+
+	columns := parseInts(*columnList, "column")
+	if flags.NArg() != 1 || *labelColumn < 0 || *threadN < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading file...")
+	_, rows := readCSVN(flags.Arg(0), append([]int{*labelColumn}, columns...))
+	points := make([][]float64, len(rows))
+	labels := make([]int, len(rows))
+	for i, row := range rows {
+		labels[i] = int(row[0])
+		points[i] = row[1:]
+	}
+	// Noise points are not in the points files, they have no coordinates and only count for DBCV
+	for len(points) < *total {
+		points = append(points, nil)
+		labels = append(labels, 0)
+	}
+
+	fmt.Println("Evaluating", len(rows), "points...")
+	scores := evaluate.Evaluate(points, labels, *sample, 1, *threadN)
+	fmt.Println()
+	fmt.Println("Clusters:", scores.Clusters)
+	fmt.Println("Noise points:", scores.Noise)
+	fmt.Printf("Silhouette:        %.4f (-1 to 1, higher is better)\n", scores.Silhouette)
+	fmt.Printf("Davies-Bouldin:    %.4f (0 and up, lower is better)\n", scores.DaviesBouldin)
+	fmt.Printf("Calinski-Harabasz: %.4f (higher is better)\n", scores.CalinskiHarabasz)
+	fmt.Printf("DBCV:              %.4f (-1 to 1, higher is better)\n", scores.DBCV)
+	fmt.Println()
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Differences between two clusterings of the same points
+func compareCommand(args []string) {
+	flags := newCommandFlags("compare", "[flags] <points_file_a> <points_file_b>")
+	labelColumn := flags.Int("labelColumn", 0, "index of the CSV column holding the cluster (0 is noise)")
+	columnList := flags.String("columns", "1,2", "comma separated indices of the CSV columns identifying a point")
+	total := flags.Int("total", 0, "number of points in the clustered file, the points missing from both files are noise in both (0 leaves them out)")
+	top := flags.Int("top", 20, "number of matched clusters to print")
+	contingencyOut := flags.String("contingency", "./contingency.csv", "contingency table output")
+	matchingOut := flags.String("matching", "./matching.csv", "cluster matching output")
+	flags.Parse(args)
+
+	columns := parseInts(*columnList, "column")
+	if flags.NArg() != 2 || *labelColumn < 0 || *total < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	fmt.Println("Reading files...")
+	a := readLabelsByLocation(flags.Arg(0), *labelColumn, columns)
+	b := readLabelsByLocation(flags.Arg(1), *labelColumn, columns)
+
+	// Points missing from a file are noise there
+	labelsA, labelsB := []int{}, []int{}
+	onlyA, onlyB := 0, 0
+	for location, label := range a {
+		if _, ok := b[location]; !ok {
+			onlyA++
+		}
+		labelsA = append(labelsA, label)
+		labelsB = append(labelsB, b[location])
+	}
+	for location, label := range b {
+		if _, ok := a[location]; !ok {
+			onlyB++
+			labelsA = append(labelsA, 0)
+			labelsB = append(labelsB, label)
+		}
+	}
+
+	// points.csv leaves the noise out, the points in neither file agree on being noise
+	noise := *total - len(labelsA)
+	if *total > 0 && noise < 0 {
+		fmt.Fprintln(os.Stderr, "Error: the files hold", len(labelsA), "points, more than -total", *total)
+		os.Exit(1)
+	}
+	for i := 0; i < noise; i++ {
+		labelsA = append(labelsA, 0)
+		labelsB = append(labelsB, 0)
+	}
+
+	clustersA, _ := labelSummary(labelsA)
+	clustersB, _ := labelSummary(labelsB)
+	matches := evaluate.MatchClusters(labelsA, labelsB)
+	fmt.Println()
+	fmt.Println("Points:", len(labelsA), "| Only clustered in A:", onlyA, "| Only clustered in B:", onlyB)
+	if noise > 0 {
+		fmt.Println("Noise in both:", noise)
+	} else if *total == 0 {
+		fmt.Println("Noise in both files is left out of the scores, set -total to count it")
+	}
+	fmt.Println("Clusters in A:", clustersA, "| Clusters in B:", clustersB, "| Matched:", len(matches))
+	fmt.Printf("Adjusted Rand Index: %.4f\n", evaluate.AdjustedRandIndex(labelsA, labelsB))
+	fmt.Printf("NMI:                 %.4f\n", evaluate.NMI(labelsA, labelsB))
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "A\tB\tSizeA\tSizeB\tOverlap\tJaccard\t")
+	for i, m := range matches {
+		if i >= *top {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%.3f\t\n", m.A, m.B, m.SizeA, m.SizeB, m.Overlap, m.Jaccard)
+	}
+	w.Flush()
+	fmt.Println()
+
+	fmt.Println("Saving results...")
+	writeContingencyCSV(*contingencyOut, evaluate.NewContingency(labelsA, labelsB))
+	writeMatchingCSV(*matchingOut, matches)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Synthetic dataset in the input format
+func generateCommand(args []string) {
+	flags := newCommandFlags("generate", "[flags] <output_file>")
+	kind := flags.String("kind", "blobs", "shape of the clusters: blobs, density (blobs of varying density), moons, rings or uniform")
+	n := flags.Int("n", 100_000, "number of points in the clusters")
+	k := flags.Int("clusters", 10, "number of clusters (blobs, density and rings)")
+	spread := flags.Float64("spread", 0.005, "spread of the clusters, relative to the size of the area")
+	noise := flags.Int("noise", 10_000, "number of uniform noise points added")
+	seed := flags.Int64("seed", 1, "random seed, the same seed always gives the same points")
+	boundList := flags.String("bounds", "-74.05,40.6,0.3,0.3", "x,y,w,h of the area the points are in")
+	startTime := flags.String("start", "2016-01-01 00:00:00", "time of the first point")
+	duration := flags.Duration("duration", 24*time.Hour, "time between the first and the last point")
+	flags.Parse(args)
+
+	bounds := strings.Split(*boundList, ",")
+	area := make([]float64, len(bounds))
+	for i, b := range bounds {
+		area[i], _ = strconv.ParseFloat(strings.TrimSpace(b), 64)
+	}
+	start, err := time.Parse("2006-01-02 15:04:05", *startTime)
+	if flags.NArg() != 1 || len(area) != 4 || err != nil || *n < 0 || *noise < 0 || *k < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	out := flags.Arg(0)
+
+	g := generate.New(*seed)
+	var d generate.Dataset
+	switch *kind {
+	case "blobs":
+		d = g.Blobs(*n, *k, *spread)
+	case "density":
+		d = g.VaryingDensity(*n, *k, *spread)
+	case "moons":
+		d = g.Moons(*n, *spread)
+	case "rings":
+		d = g.Rings(*n, *k, *spread)
+	case "uniform":
+		d = g.Uniform(*n)
+	default:
+		fmt.Fprintln(os.Stderr, "Error: unknown kind", *kind)
+		os.Exit(2)
+	}
+	d = g.Shuffle(generate.Merge(d, g.Uniform(*noise))).Scale(area[0], area[1], area[2], area[3])
+
+	fmt.Println("Writing", len(d.Points), "points in", d.Clusters(), "clusters to", out)
+	writeGeneratedCSV(out, d, start, *duration)
+}
+
+// Builds the tree of a CSV file and saves it, main and sweep accept the saved tree instead of the CSV file
+func indexCommand(args []string) {
+	flags := newCommandFlags("index", "[flags] <input_file> <index_file>")
+	weightColumn := flags.Int("weightColumn", -1, "index of the CSV column holding the weight of each point (saved in the tree)")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	bsp := readInputTree(flags.Arg(0), *weightColumn, os.Stdout)
+	fmt.Println("Saving", bsp.Size(), "points in", bsp.IDs(), "locations...")
+	if err := SaveBSPTree(flags.Arg(1), bsp); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if info, err := os.Stat(flags.Arg(1)); err == nil {
+		fmt.Println("Saved", info.Size(), "bytes to", flags.Arg(1))
+	}
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
+
+// Labels the points of a CSV file with a saved model, noise points are kept with the label 0
+func predictCommand(args []string) {
+	flags := newCommandFlags("predict", "[flags] <model_file> <input_file>")
+	out := flags.String("out", "./predictions.csv", "labeled points output")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startT := time.Now()
+	model, err := LoadModel(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Model: %d clusters, %d core points, epsilon %g, minPts %d\n", model.Clusters, len(model.labels), model.Epsilon, model.MinPts)
+
+	fmt.Println("Reading file...")
+	_, points := readCSV(flags.Arg(1))
+	labels := make([]int, len(points))
+	noise := 0
+	for i, p := range points {
+		labels[i] = model.Predict(p)
+		if labels[i] == 0 {
+			noise++
+		}
+	}
+	fmt.Printf("Points: %d | In clusters: %d | Noise: %d\n", len(points), len(points)-noise, noise)
+
+	fmt.Println("Saving results...")
+	writePredictions(*out, points, labels)
+	fmt.Println("Total elapsed time:", time.Since(startT))
+}
@@ -8,20 +8,33 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"dbscan/evaluate"
+	"dbscan/generate"
 )
 
 // Reads the CSV file and returns a list of points and a bounding box
 func readCSV(filename string) (Rect, []Point) {
+	rect, list, _ := readWeightedCSV(filename, -1)
+	return rect, list
+}
+
+// Reads the CSV file along with the weight of each point from weightColumn
+// A negative column means no weights (nil), invalid weights count as 0
+func readWeightedCSV(filename string, weightColumn int) (Rect, []Point, []float64) {
 	// Open the file
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return Rect{}, nil
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return Rect{}, nil, nil
 	}
 	defer file.Close()
 
 	// Create a new list
 	list := make([]Point, 0)
+	var weights []float64
+	invalid := 0
 
 	readFirstLine := false
 	minX, minY, maxX, maxY := math.MaxFloat64, math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
@@ -38,6 +51,18 @@ func readCSV(filename string) (Rect, []Point) {
 		scale := 1.0
 		p := Point{x * scale, y * scale}
 
+		if weightColumn >= 0 {
+			weight := 0.0
+			if weightColumn < len(fields) {
+				weight, err = strconv.ParseFloat(strings.TrimSpace(fields[weightColumn]), 64)
+			}
+			if weightColumn >= len(fields) || err != nil || weight < 0 {
+				weight = 0
+				invalid++
+			}
+			weights = append(weights, weight)
+		}
+
 		if !readFirstLine {
 			readFirstLine = true
 			minX = p.x
@@ -62,58 +87,478 @@ func readCSV(filename string) (Rect, []Point) {
 
 		list = append(list, p)
 	}
+	if invalid > 0 {
+		fmt.Fprintln(os.Stderr, "Warning:", invalid, "rows without a valid weight count as 0")
+	}
+
+	return boundsRect(minX, minY, maxX, maxY), list, weights
+}
 
-	return Rect{minX, minY, maxX - minX, maxY - minY}, list
+// Reads the given columns of the CSV file as N-dimensional points
+// Returns the names of the columns (from the header) and the points
+func readCSVN(filename string, columns []int) ([]string, []PointN) {
+	// Open the file
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return nil, nil
+	}
+	defer file.Close()
+
+	list := make([]PointN, 0)
+	names := make([]string, len(columns))
+
+	scanner := bufio.NewScanner(file)
+	// The first line holds the names
+	scanner.Scan()
+	header := strings.Split(scanner.Text(), ",")
+	for i, c := range columns {
+		names[i] = fmt.Sprint("Column", c)
+		if c < len(header) {
+			names[i] = strings.TrimSpace(header[c])
+		}
+	}
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		p := make(PointN, len(columns))
+		for i, c := range columns {
+			if c < len(fields) {
+				p[i], _ = strconv.ParseFloat(strings.TrimSpace(fields[c]), 64)
+			}
+		}
+		list = append(list, p)
+	}
+
+	return names, list
+}
+
+// Saves the clustered N-dimensional points to a CSV file
+func writeNClusterPoints(filename string, names []string, points []PointN, labels []int) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterId," + strings.Join(names, ",") + "\n")
+	for i, p := range points {
+		if labels[i] == 0 {
+			continue
+		}
+		fields := make([]string, len(p))
+		for d, v := range p {
+			fields[d] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		file.WriteString(fmt.Sprintf("%d,%s\n", labels[i], strings.Join(fields, ",")))
+	}
+}
+
+// Reads the CSV file along with the time of each point
+// Rows with a time that does not match the layout are skipped
+func readTimedCSV(filename string, timeColumn int, layout string) (Rect, []TimedPoint) {
+	// Open the file
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return Rect{}, nil
+	}
+	defer file.Close()
+
+	list := make([]TimedPoint, 0)
+	points := make([]Point, 0)
+	skipped := 0
+
+	// Read the file line by line
+	scanner := bufio.NewScanner(file)
+	// Skip the first line
+	scanner.Scan()
+	for scanner.Scan() {
+		// Same x-y coordinates as readCSV
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 10 || timeColumn >= len(fields) {
+			skipped++
+			continue
+		}
+		t, err := time.Parse(layout, strings.TrimSpace(fields[timeColumn]))
+		if err != nil {
+			skipped++
+			continue
+		}
+		x, _ := strconv.ParseFloat(fields[8], 64)
+		y, _ := strconv.ParseFloat(fields[9], 64)
+		p := Point{x, y}
+
+		list = append(list, TimedPoint{p, t})
+		points = append(points, p)
+	}
+	if skipped > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: skipped", skipped, "rows without a valid time")
+	}
+
+	return pointsBounds(points), list
+}
+
+// Writes the clusters of every time window to a CSV file
+func writeWindowCSV(filename string, windows []ClusterWindow) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("Window,Start,End,ClusterId,Latitude,Longitude,Size\n")
+
+	// Clusters are already sorted by size in each window
+	for w, window := range windows {
+		start := window.start.Format(time.RFC3339)
+		end := window.end.Format(time.RFC3339)
+		for i, cluster := range window.clusters {
+			p := cluster.Average()
+			file.WriteString(fmt.Sprintf("%d,%s,%s,%d,%f,%f,%d\n", w, start, end, i+1, p.y, p.x, cluster.Size()))
+		}
+	}
+}
+
+// Writes the links between clusters of consecutive windows to a CSV file
+func writeLineageCSV(filename string, lineage []ClusterLineage) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("Window,ClusterId,PreviousClusterId,SharedPoints\n")
+	for _, l := range lineage {
+		file.WriteString(fmt.Sprintf("%d,%d,%d,%d\n", l.window, l.cluster, l.prevCluster, l.shared))
+	}
+}
+
+// Writes the ST-DBSCAN clusters (average position and time span) to a CSV file
+func writeSTClusters(filename string, points []TimedPoint, labels []int) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Group the points by cluster
+	clusters := make(map[int][]TimedPoint)
+	ids := []int{}
+	for i, label := range labels {
+		if label == 0 {
+			continue
+		}
+		if _, ok := clusters[label]; !ok {
+			ids = append(ids, label)
+		}
+		clusters[label] = append(clusters[label], points[i])
+	}
+	sort.Ints(ids)
+
+	// Write the header
+	file.WriteString("ClusterId,Latitude,Longitude,Start,End,Size\n")
+	for _, id := range ids {
+		cluster := clusters[id]
+		positions := make([]Point, len(cluster))
+		start, end := cluster[0].t, cluster[0].t
+		for i, p := range cluster {
+			positions[i] = p.Point
+			if p.t.Before(start) {
+				start = p.t
+			}
+			if p.t.After(end) {
+				end = p.t
+			}
+		}
+		p := pointAverage(positions)
+		file.WriteString(fmt.Sprintf("%d,%f,%f,%s,%s,%d\n", id, p.y, p.x,
+			start.Format(time.RFC3339), end.Format(time.RFC3339), len(cluster)))
+	}
+}
+
+// Saves the clustered points of ST-DBSCAN to a CSV file
+func writeSTClusterPoints(filename string, points []TimedPoint, labels []int) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterId,Latitude,Longitude,Time\n")
+	for i, p := range points {
+		if labels[i] != 0 {
+			file.WriteString(fmt.Sprintf("%d,%f,%f,%s\n", labels[i], p.y, p.x, p.t.Format(time.RFC3339)))
+		}
+	}
+}
+
+// Saves the clustered locations of HDBSCAN with their membership probability to a CSV file
+func writeHDBSCANPoints(filename string, res HDBSCANResult) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterId,Latitude,Longitude,Probability\n")
+	for i, p := range res.points {
+		if res.labels[i] != 0 {
+			file.WriteString(fmt.Sprintf("%d,%f,%f,%f\n", res.labels[i], p.y, p.x, res.probabilities[i]))
+		}
+	}
+}
+
+// Writes the OPTICS ordering (the reachability plot) to a CSV file
+func writeOPTICSCSV(filename string, res OPTICSResult) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("Order,Latitude,Longitude,Count,Weight,Reachability,CoreDistance,Predecessor\n")
+	for i, p := range res.points {
+		file.WriteString(fmt.Sprintf("%d,%s,%s,%d,%s,%s,%s,%d\n", i,
+			strconv.FormatFloat(p.y, 'f', -1, 64), strconv.FormatFloat(p.x, 'f', -1, 64), p.cnt,
+			strconv.FormatFloat(p.weight, 'g', -1, 64),
+			formatDistance(res.reachability[i]), formatDistance(res.core[i]), res.predecessor[i]))
+	}
+}
+
+// Undefined distances are written as inf
+func formatDistance(d float64) string {
+	if math.IsInf(d, 1) {
+		return "inf"
+	}
+	return strconv.FormatFloat(d, 'g', -1, 64)
+}
+
+// Reads an OPTICS ordering written by writeOPTICSCSV
+func readOPTICSCSV(filename string) OPTICSResult {
+	res := OPTICSResult{}
+
+	// Open the file
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return res
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Skip the first line
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 8 {
+			continue
+		}
+		y, _ := strconv.ParseFloat(fields[1], 64)
+		x, _ := strconv.ParseFloat(fields[2], 64)
+		cnt, _ := strconv.Atoi(fields[3])
+		weight, _ := strconv.ParseFloat(fields[4], 64)
+		reachability, _ := strconv.ParseFloat(fields[5], 64)
+		core, _ := strconv.ParseFloat(fields[6], 64)
+		predecessor, _ := strconv.Atoi(fields[7])
+
+		res.points = append(res.points, BSPTreePoint{&Point{x, y}, cnt, weight, len(res.points)})
+		res.reachability = append(res.reachability, reachability)
+		res.core = append(res.core, core)
+		res.predecessor = append(res.predecessor, predecessor)
+	}
+	return res
+}
+
+// Saves the clustered locations to a CSV file (same format as writeClusterPoints)
+func writeLabeledPoints(filename string, points []BSPTreePoint, labels []int) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterId,Latitude,Longitude\n")
+	for i, p := range points {
+		if labels[i] != 0 {
+			file.WriteString(fmt.Sprintf("%d,%f,%f\n", labels[i], p.y, p.x))
+		}
+	}
+}
+
+// Saves every point with its predicted cluster, 0 for noise (same columns as writeClusterPoints)
+func writePredictions(filename string, points []Point, labels []int) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterId,Latitude,Longitude\n")
+	for i, p := range points {
+		file.WriteString(fmt.Sprintf("%d,%f,%f\n", labels[i], p.y, p.x))
+	}
+}
+
+// Saves the summary of a parameter sweep, one line per combination
+func writeSweepCSV(filename string, results []SweepResult) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("Epsilon,MinPts,Clusters,NoiseFraction,LargestCluster,RuntimeSeconds,Silhouette\n")
+	for _, res := range results {
+		file.WriteString(fmt.Sprintf("%s,%d,%d,%f,%d,%f,%f\n", strconv.FormatFloat(res.epsilon, 'f', -1, 64),
+			res.minPts, res.clusters, res.noise, res.largest, res.runtime.Seconds(), res.silhouette))
+	}
+}
+
+// Reads the cluster of every point of a points file, keyed by its coordinates
+// Repeated coordinates are told apart by their occurrence, so the n-th copy in a file matches the n-th copy in another.
+func readLabelsByLocation(filename string, labelColumn int, columns []int) map[string]int {
+	_, rows := readCSVN(filename, append([]int{labelColumn}, columns...))
+	labels := make(map[string]int, len(rows))
+	seen := make(map[string]int)
+	for _, row := range rows {
+		location := fmt.Sprint(row[1:])
+		labels[fmt.Sprint(location, "#", seen[location])] = int(row[0])
+		seen[location]++
+	}
+	return labels
+}
+
+// Saves the non empty cells of a contingency table
+func writeContingencyCSV(filename string, c evaluate.Contingency) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterA,ClusterB,Points\n")
+	for r, row := range c.Counts {
+		for k, count := range row {
+			if count > 0 {
+				file.WriteString(fmt.Sprintf("%d,%d,%d\n", c.RowLabels[r], c.ColLabels[k], count))
+			}
+		}
+	}
+}
+
+// Saves the matched clusters of two clusterings
+func writeMatchingCSV(filename string, matches []evaluate.Match) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("ClusterA,ClusterB,SizeA,SizeB,Overlap,Jaccard\n")
+	for _, m := range matches {
+		file.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%f\n", m.A, m.B, m.SizeA, m.SizeB, m.Overlap, m.Jaccard))
+	}
+}
+
+// Saves a generated dataset in the format of the input files: x in column 8, y in column 9 and the time in column 1.
+// The points are spread evenly over the duration, in the order of the dataset. The generated cluster is in the last column.
+func writeGeneratedCSV(filename string, d generate.Dataset, start time.Time, duration time.Duration) {
+	// Open the file
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	defer file.Close()
+
+	// Write the header
+	file.WriteString("Id,Time,Column2,Column3,Column4,Column5,Column6,Column7,Longitude,Latitude,Label\n")
+	w := bufio.NewWriter(file)
+	for i, p := range d.Points {
+		t := start.Add(time.Duration(float64(duration) * float64(i) / float64(len(d.Points))))
+		w.WriteString(fmt.Sprintf("%d,%s,0,0,0,0,0,0,%s,%s,%d\n", i, t.Format("2006-01-02 15:04:05"),
+			strconv.FormatFloat(p.X, 'f', -1, 64), strconv.FormatFloat(p.Y, 'f', -1, 64), d.Labels[i]))
+	}
+	w.Flush()
 }
 
 // Writes the list of clusters to a CSV file
 func writeCSV(filename string, clusters []Cluster) {
-	// Sort the clusters by length of each item
-	sort.Slice(clusters, func(i, j int) bool {
+	// Sort the clusters by length of each item, clusters of the same length keep their order
+	sort.SliceStable(clusters, func(i, j int) bool {
 		return len(clusters[i].points) > len(clusters[j].points)
 	})
 
 	// Open the file
 	file, err := os.Create(filename)
 	if err != nil {
-		fmt.Println("Error:", err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		return
 	}
 	defer file.Close()
 
 	// Write the header
-	file.WriteString("ClusterId,Latitude,Longitude,Size\n")
+	file.WriteString("ClusterId,Latitude,Longitude,Size,Weight\n")
 
 	// Write the clusters
 	clusterId := 0
 	for _, cluster := range clusters {
-		// Get the cluster
 		// Get the average point
-		points := []Point{}
-		clusterSize := 0
-		for _, p := range cluster.points {
-			points = append(points, *p.Point)
-			clusterSize += p.cnt
-		}
-		p := pointAverage(points)
+		p := cluster.Average()
 		// Write the cluster to the file
-
-		file.WriteString(fmt.Sprintf("%d,%f,%f,%d\n", clusterId, p.y, p.x, clusterSize))
+		file.WriteString(fmt.Sprintf("%d,%f,%f,%d,%s\n", clusterId, p.y, p.x, cluster.Size(),
+			strconv.FormatFloat(cluster.Weight(), 'f', -1, 64)))
 		clusterId++
 	}
 }
 
 // Saves the cluster points from a list of clusters to a CSV file
 func writeClusterPoints(filename string, clusters []Cluster) {
-	// Sort the clusters by length of each item
-	sort.Slice(clusters, func(i, j int) bool {
+	// Sort the clusters by length of each item, clusters of the same length keep their order
+	sort.SliceStable(clusters, func(i, j int) bool {
 		return len(clusters[i].points) > len(clusters[j].points)
 	})
 
 	// Open the file
 	file, err := os.Create(filename)
 	if err != nil {
-		fmt.Println("Error:", err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		return
 	}
 	defer file.Close()
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadWeightedCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.csv")
+	data := "a,b,c,d,e,f,g,h,lon,lat,passengers\n" +
+		"0,0,0,0,0,0,0,0,1,2,3\n" +
+		"0,0,0,0,0,0,0,0,4,-1,1.5\n" +
+		"0,0,0,0,0,0,0,0,2,5,oops\n"
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rect, points, weights := readWeightedCSV(filename, 10)
+	if len(points) != 3 || len(weights) != 3 {
+		t.Fatalf("Read %d points and %d weights", len(points), len(weights))
+	}
+	if points[1] != (Point{4, -1}) || weights[0] != 3 || weights[1] != 1.5 || weights[2] != 0 {
+		t.Errorf("Wrong points %v or weights %v", points, weights)
+	}
+	if rect != (Rect{1, -1, 3, 6}) {
+		t.Errorf("Wrong bounds %v", rect)
+	}
+
+	_, _, weights = readWeightedCSV(filename, -1)
+	if weights != nil {
+		t.Error("Weights without a weight column")
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"sync"
+	"context"
+	"sync/atomic"
 )
 
 // Cluster holds a rect and a list of points
@@ -10,171 +11,482 @@ type Cluster struct {
 	points []BSPTreePoint
 }
 
-// Thread pool job producer that returns partitions of points that are within the maxJobSize threshold.
+// Number of points in the cluster
+func (c Cluster) Size() int {
+	size := 0
+	for _, p := range c.points {
+		size += p.cnt // cnt is the number of points in the coordinate
+	}
+	return size
+}
+
+// Sum of the weights of the cluster points
+func (c Cluster) Weight() float64 {
+	weight := 0.0
+	for _, p := range c.points {
+		weight += p.weight
+	}
+	return weight
+}
+
+// Weighted average position of the cluster points
+func (c Cluster) Average() Point {
+	points := make([]Point, len(c.points))
+	weights := make([]float64, len(c.points))
+	for i, p := range c.points {
+		points[i] = *p.Point
+		weights[i] = p.weight
+	}
+	return pointWeightedAverage(points, weights)
+}
+
+// A part of the points that a worker clusters on its own: a subtree of the BSPTree or of the PartitionTree
+type dbscanJob interface {
+	// Number of points
+	size() int
+	// Estimated cost of clustering the points
+	cost(epsilon float64) float64
+	// Both subtrees, nil if the job can't be split
+	halves() (dbscanJob, dbscanJob)
+	// Labels the points in the shared state, returns the number of core points
+	run(ctx context.Context, state *dbscanState, epsilon float64, minPts int) int
+}
+
+// Subtree of the BSPTree, the neighbors are queried in an index of the whole tree
+type bspJob struct {
+	tree  *BSPTree
+	index SpatialIndex
+}
+
+func (j bspJob) size() int {
+	return j.tree.size
+}
+
+func (j bspJob) cost(epsilon float64) float64 {
+	return jobCost(j.tree, epsilon)
+}
+
+func (j bspJob) halves() (dbscanJob, dbscanJob) {
+	if j.tree.left == nil || j.tree.right == nil {
+		return nil, nil
+	}
+	return bspJob{j.tree.left, j.index}, bspJob{j.tree.right, j.index}
+}
+
+func (j bspJob) run(ctx context.Context, state *dbscanState, epsilon float64, minPts int) int {
+	return dbscan(ctx, j.tree, j.index, state, epsilon, minPts)
+}
+
+// Splits the tree into jobs (subtrees) that are within the maxJobSize threshold.
 // Note that maxJobSize is not guaranteed if tree can't be futher broken down.
-func dbscanProducer(bsp *BSPTree, maxJobSize int, wg *sync.WaitGroup) <-chan *BSPTree {
-	outJobs := make(chan *BSPTree, maxJobSize)
-	wg.Add(1)
-
-	go func() { // Iterate over tree for nodes that satisfy the maxJobSize threshold
-		q := []*BSPTree{bsp}
-		for len(q) > 0 {
-			// Pop
-			current := q[0]
-			q = q[1:]
-
-			if current.size > maxJobSize { // If size is too big, split
-				// Split
-				if current.left != nil && current.right != nil {
-					q = append(q, current.left)
-					q = append(q, current.right)
-				} else { // Can't split, just add it anyway
-					outJobs <- current
-				}
-			} else if current.size > 0 { // Add to jobs
-				outJobs <- current
+// A maxJobSize of 0 leaves the whole tree to the scheduler.
+func dbscanJobs(root dbscanJob, maxJobSize int) []dbscanJob {
+	jobs := []dbscanJob{}
+	q := []dbscanJob{root}
+	for len(q) > 0 {
+		// Pop
+		current := q[0]
+		q = q[1:]
+
+		if maxJobSize > 0 && current.size() > maxJobSize { // If size is too big, split
+			// Split
+			if left, right := current.halves(); left != nil {
+				q = append(q, left, right)
+			} else { // Can't split, just add it anyway
+				jobs = append(jobs, current)
 			}
+		} else if current.size() > 0 { // Add to jobs
+			jobs = append(jobs, current)
 		}
-		close(outJobs)
-		wg.Done()
-	}()
-
-	return outJobs
+	}
+	return jobs
 }
 
-// A simple thread pool worker that wait for jobs and processes them
-func dbscanWorker(bsp <-chan *BSPTree, res chan<- Cluster, epsilon float64, wg *sync.WaitGroup) {
-	for bspJob := range bsp {
-		dbscan(bspJob, epsilon, res)
+// Runs the jobs on a work-stealing scheduler, every job labels its own locations in the shared state
+// The jobs are cut from the tree by maxJobSize (0 to let the scheduler size them), the neighbors are queried
+// in the index (nil for the tree itself).
+// Returns once every job is done, or soon after the context is done (the state then holds the part that was processed).
+// Progress is reported after every job, the last event of the phase has the stats of every worker (progress may be nil).
+func dbscanParallel(ctx context.Context, bspRoot *BSPTree, index SpatialIndex, epsilon float64, minPts int, maxJobSize int, nWorkers int,
+	progress *progressReporter) *dbscanState {
+	if index == nil {
+		index = bspRoot
 	}
-	wg.Done()
+	return dbscanRun(ctx, bspJob{bspRoot, index}, bspRoot.IDs(), epsilon, minPts, maxJobSize, nWorkers, progress)
 }
 
-// Returns a channel containing the unmerged clusters
-func dbscanParallel(bspRoot *BSPTree, epsilon float64, maxJobSize int, nWorkers int) <-chan Cluster {
-	var (
-		wg   sync.WaitGroup
-		res  = make(chan Cluster, maxJobSize)
-		jobs = dbscanProducer(bspRoot, maxJobSize, &wg)
-	)
+// Same as dbscanParallel for any tree, the jobs label ids 0 to ids - 1
+func dbscanRun(ctx context.Context, root dbscanJob, ids int, epsilon float64, minPts int, maxJobSize int, nWorkers int,
+	progress *progressReporter) *dbscanState {
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+	jobs := dbscanJobs(root, maxJobSize)
+	progress.update(func(p *Progress) {
+		*p = Progress{Phase: PhaseClustering, JobsTotal: len(jobs), PointsTotal: root.size()}
+	})
+
+	state := newDBSCANState(ids)
+	stats := newScheduler(state, jobs, epsilon, minPts, maxJobSize, nWorkers).run(ctx, progress)
+	progress.update(func(p *Progress) {
+		p.Workers = stats
+	})
+	return state
+}
 
-	for i := 0; i < nWorkers; i++ {
-		wg.Add(1)
-		go dbscanWorker(jobs, res, epsilon, &wg)
+// Runs the whole pipeline: parallel union-find DBSCAN, then one cluster per set (minPts is compared to the weights)
+// The neighbors are queried in the index (nil for the tree itself).
+// If the context is done before the end, returns the clusters of the part that was processed and the context error.
+// Every step is reported to the progress callback (which may be nil).
+func dbscanClusters(ctx context.Context, bsp *BSPTree, index SpatialIndex, epsilon float64, minPts int, maxJobSize int, nWorkers int,
+	progress func(Progress)) ([]Cluster, error) {
+	if index == nil {
+		index = bsp
 	}
+	reporter := newProgressReporter(progress)
+	clusters := dbscanParallel(ctx, bsp, index, epsilon, minPts, maxJobSize, nWorkers, reporter).clusters(index, reporter)
+	reporter.update(func(p *Progress) {
+		p.Phase = PhaseDone
+		p.Clusters = len(clusters)
+	})
+	return clusters, ctx.Err()
+}
 
-	go func() {
-		wg.Wait()
-		close(res)
-	}()
+// Labeling shared by the jobs of a run, indexed by location id (see BSPTree.IDs)
+// Every slot is only accessed with atomics, so the jobs can read what the others found.
+type dbscanState struct {
+	core   []int32 // 1 for core points, 2 for the other points, 0 until a job checks
+	parent []int32 // Union-find over the core points, the sets are the clusters
+	border []int32 // Core point next to every border point + 1, 0 for noise
+}
 
-	return res
+func newDBSCANState(ids int) *dbscanState {
+	s := &dbscanState{
+		core:   make([]int32, ids),
+		parent: make([]int32, ids),
+		border: make([]int32, ids),
+	}
+	for i := range s.parent {
+		s.parent[i] = int32(i)
+	}
+	return s
 }
 
-// Perform DBSCAN clustering from a spatial partitioning tree
-// Returns a list of points that are within epsilon distance of the query point
-func dbscan(bsp *BSPTree, epsilon float64, res chan<- Cluster) {
-	visited := make(map[BSPTreePoint]bool)
-	// For each point in the tree
-	for pQuery := range bsp.Iterate() {
-		mainPoint := pQuery.Point
-		// If key in visited map, skip
-		if _, ok := visited[pQuery]; ok {
-			continue
+// Root of the set of a core point, halves the path on the way up
+// Lock-free: a node only ever gets a parent closer to the root.
+func (s *dbscanState) find(i int32) int32 {
+	for {
+		p := atomic.LoadInt32(&s.parent[i])
+		if p == i {
+			return i
+		}
+		gp := atomic.LoadInt32(&s.parent[p])
+		if gp != p {
+			atomic.CompareAndSwapInt32(&s.parent[i], p, gp)
 		}
+		i = p
+	}
+}
 
-		// Calculate cluster bounding box
-		minX := mainPoint.x
-		minY := mainPoint.y
-		maxX := mainPoint.x
-		maxY := mainPoint.y
+// Merges the sets of two core points
+// Lock-free: the larger root is linked under the smaller one, only if it is still a root.
+func (s *dbscanState) union(a, b int32) {
+	for {
+		a, b = s.find(a), s.find(b)
+		if a == b {
+			return
+		}
+		if a < b {
+			a, b = b, a
+		}
+		if atomic.CompareAndSwapInt32(&s.parent[a], a, b) {
+			return
+		}
+	}
+}
 
-		// Find neighbors (including the point itself)
-		r := Rect{mainPoint.x - epsilon, mainPoint.y - epsilon, epsilon * 2, epsilon * 2}
-		toVisit := []BSPTreePoint{}
-		for n := range bsp.QueryAsync(r) {
-			toVisit = append(toVisit, n)
+// Perform DBSCAN on the points of a job (a subtree of the root)
+// Neighbors are queried in an index of the whole tree, so the density of the points on the edges of the job is exact:
+// a point is a core point if the weight of the points within epsilon (itself included) is at least minPts.
+// Every core point of the job is united with the core points within epsilon (of any job), every other point
+// is attached to the closest core point within epsilon, if any (see closerTo, Model.Predict picks the same one).
+// No point of the job is left for another job to decide.
+// Returns the number of core points, early when the context is done.
+func dbscan(ctx context.Context, job *BSPTree, index SpatialIndex, state *dbscanState, epsilon float64, minPts int) int {
+	found := 0
+	// Core status, computed once by the first job that needs it
+	isCore := func(p BSPTreePoint) bool {
+		c := atomic.LoadInt32(&state.core[p.id])
+		if c == 0 {
+			c = 2
+			if index.WeightWithin(*p.Point, epsilon, float64(minPts)) >= float64(minPts) {
+				c = 1
+			}
+			atomic.StoreInt32(&state.core[p.id], c)
 		}
-		clusterPoints := []BSPTreePoint{}
+		return c == 1
+	}
 
-		// Recursively visit neighbors
-		for len(toVisit) > 0 {
-			current := toVisit[0]
-			toVisit = toVisit[1:]
+	neighbors := []BSPTreePoint{} // Reused by every query
+	// For each point in the tree
+	for p := range job.Iterate() {
+		if ctx.Err() != nil {
+			return found
+		}
+		core := isCore(p)
 
-			// If key in visited map, skip
-			if _, ok := visited[current]; ok {
+		// Query neighbors in the whole tree, the points of other jobs are the halo
+		neighbors = index.RadiusQuery(*p.Point, epsilon, neighbors[:0])
+		set := int32(p.id)
+		var closest *BSPTreePoint
+		for i, n := range neighbors {
+			if n.id == p.id || !isCore(n) {
 				continue
 			}
-
-			// Query neighbors, excluding the point itself
-			r := Rect{current.x - epsilon, current.y - epsilon, epsilon * 2, epsilon * 2}
-			for n := range bsp.QueryAsync(r) {
-				// If point is within epsilon distance, add to toVisit
-				if n.Point.Distance(*current.Point) <= epsilon && !pointIntersect(*n.Point, *mainPoint) {
-					toVisit = append(toVisit, n)
+			if !core { // Border point, attached to the closest core point
+				if closest == nil || closerTo(*p.Point, *n.Point, *closest.Point) {
+					closest = &neighbors[i]
 				}
+				continue
 			}
-			visited[current] = true // Mark as visited
+			if atomic.LoadInt32(&state.parent[n.id]) == set {
+				continue // Already in the set, skips most of the unions in dense areas
+			}
+			state.union(set, int32(n.id))
+			set = state.find(set)
+		}
+		if closest != nil {
+			atomic.StoreInt32(&state.border[p.id], int32(closest.id)+1)
+		}
+		if core {
+			found++
+		}
+	}
+	return found
+}
+
+// Is a closer to p than b? Ties go to the smallest x, then the smallest y,
+// so that every border point has a single closest core point
+func closerTo(p Point, a Point, b Point) bool {
+	da, db := p.Distance(a), p.Distance(b)
+	if da != db {
+		return da < db
+	}
+	return a.x < b.x || a.x == b.x && a.y < b.y
+}
 
-			clusterPoints = append(clusterPoints, current) // Add to cluster
+// Set of the core point of a location or of the core point a border point is attached to, -1 for noise
+func (s *dbscanState) set(id int) int32 {
+	if atomic.LoadInt32(&s.core[id]) == 1 {
+		return s.find(int32(id))
+	}
+	if b := atomic.LoadInt32(&s.border[id]); b != 0 {
+		return s.find(b - 1)
+	}
+	return -1
+}
 
-			// Update bounding box
-			if current.x < minX {
-				minX = current.x
+// Cluster of every id, starting at 1 in the order of their first id, 0 means noise
+func (s *dbscanState) labels() []int {
+	labels := make([]int, len(s.core))
+	clusterOf := make(map[int32]int) // Cluster of every set
+	for id := range labels {
+		if set := s.set(id); set != -1 {
+			if _, ok := clusterOf[set]; !ok {
+				clusterOf[set] = len(clusterOf) + 1
 			}
-			if current.y < minY {
-				minY = current.y
+			labels[id] = clusterOf[set]
+		}
+	}
+	return labels
+}
+
+// One cluster per set of core points, with their border points
+// The locations are read from the index, clusters are in the order of their first location in it.
+// Progress is reported every 1% of the locations (progress may be nil).
+func (s *dbscanState) clusters(index SpatialIndex, progress *progressReporter) []Cluster {
+	locations := index.RangeQuery(index.Bounds(), nil)
+	progress.update(func(p *Progress) {
+		p.Phase = PhaseMerging
+		p.MergeDone, p.MergeTotal, p.Clusters = 0, len(locations), 0
+	})
+
+	clusters := []Cluster{}
+	clusterOf := make(map[int32]int) // Cluster of every set
+	step := len(locations)/100 + 1
+	for i, p := range locations {
+		if set := s.set(p.id); set != -1 {
+			k, ok := clusterOf[set]
+			if !ok {
+				k = len(clusters)
+				clusterOf[set] = k
+				clusters = append(clusters, Cluster{Rect: Rect{p.x, p.y, 0, 0}})
 			}
-			if current.x > maxX {
-				maxX = current.x
+			clusters[k].points = append(clusters[k].points, p)
+			clusters[k].Rect = clusters[k].Rect.MergePoint(*p.Point)
+		}
+
+		if (i+1)%step == 0 || i == len(locations)-1 {
+			progress.update(func(p *Progress) {
+				p.MergeDone, p.Clusters = i+1, len(clusters)
+			})
+		}
+	}
+	return clusters
+}
+
+// Textbook DBSCAN over n points given a neighborhood function (neighbors include the point itself)
+// A point is a core point if the weight of its neighbors is at least minPts, nil weights count every point once.
+// Returns the cluster of every point, starting at 1, 0 means noise.
+func dbscanLabels(n int, weights []float64, neighbors func(i int) []int, minPts int) []int {
+	weight := func(items []int) float64 {
+		if weights == nil {
+			return float64(len(items))
+		}
+		w := 0.0
+		for _, i := range items {
+			w += weights[i]
+		}
+		return w
+	}
+
+	labels := make([]int, n)
+	visited := make([]bool, n)
+	cluster := 0
+	for i := 0; i < n; i++ {
+		if visited[i] {
+			continue
+		}
+		visited[i] = true
+		toVisit := neighbors(i)
+		if weight(toVisit) < float64(minPts) {
+			continue // Noise, unless a core point reaches it later
+		}
+
+		cluster++
+		labels[i] = cluster
+		for len(toVisit) > 0 {
+			current := toVisit[0]
+			toVisit = toVisit[1:]
+			if labels[current] == 0 {
+				labels[current] = cluster
+			}
+			if visited[current] {
+				continue
 			}
-			if current.y > maxY {
-				maxY = current.y
+			visited[current] = true
+
+			if n := neighbors(current); weight(n) >= float64(minPts) { // Expand core points only
+				toVisit = append(toVisit, n...)
 			}
 		}
-		boundingRect := Rect{minX, minY, maxX - minX, maxY - minY}
-		res <- Cluster{boundingRect, clusterPoints}
 	}
+	return labels
 }
 
-// This is a naive implementation for merging clusters
-// it does not take into account the spatial partitioning tree and runs in O(n^2) time
-// DO NOT GRADE THIS FUNCTION, THIS IS JUST AN EXTRA SO THAT I COULD SEE THE END RESULT
-func mergeClusters(clusters []Cluster, epsilon float64) []Cluster {
-	// Merge clusters
-	index := 0
+// DBSCAN on N-dimensional points, returns the cluster of every point (0 is noise)
+// The jobs of the engine are cut from the BSPTree for 2D points, from the PartitionTree otherwise.
+// nil weights count every point once.
+func dbscanN(points []PointN, weights []float64, epsilon float64, minPts int, nWorkers int) []int {
+	if len(points) > 0 && len(points[0]) == 2 {
+		return dbscan2D(points, weights, epsilon, minPts, nWorkers)
+	}
+
+	tree := NewPartitionTree(points, nil)
+	if tree.root == nil {
+		return []int{}
+	}
+	root := partitionJob{tree.root, tree, weights}
+	return dbscanRun(context.Background(), root, len(points), epsilon, minPts, 0, nWorkers, nil).labels()
+}
 
-start:
-	for index < len(clusters) {
-		current := clusters[index]
+// DBSCAN on 2D points with the BSPTree engine
+// The tree keeps a single entry per location, so the clustering runs on locations weighted by their points
+func dbscan2D(points []PointN, weights []float64, epsilon float64, minPts int, nWorkers int) []int {
+	list := make([]Point, len(points))
+	for i, p := range points {
+		list[i] = Point{p[0], p[1]}
+	}
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(list), &list, weights)
+	labels := dbscanParallel(context.Background(), bsp, nil, epsilon, minPts, 0, nWorkers, nil).labels()
 
-		// Get all neighbors
-		for i := 0; i < len(clusters); i++ {
-			if i == index {
-				continue
+	ids := make(map[Point]int, bsp.IDs())
+	for _, l := range bsp.Query(bsp.rect) {
+		ids[*l.Point] = l.id
+	}
+	res := make([]int, len(points))
+	for i, p := range list {
+		res[i] = labels[ids[p]]
+	}
+	return res
+}
+
+// Perform DBSCAN on the points of a job of the PartitionTree, like dbscan does on the BSPTree
+// The ids are the indices of the points, the points at the same location are not merged.
+func dbscanPartition(ctx context.Context, job partitionJob, state *dbscanState, epsilon float64, minPts int) int {
+	tree := job.tree
+	weight := func(items []int) float64 {
+		if job.weights == nil {
+			return float64(len(items))
+		}
+		w := 0.0
+		for _, i := range items {
+			w += job.weights[i]
+		}
+		return w
+	}
+	// Core status, computed once by the first job that needs it
+	isCore := func(i int) bool {
+		c := atomic.LoadInt32(&state.core[i])
+		if c == 0 {
+			c = 2
+			if weight(tree.Radius(tree.points[i], epsilon)) >= float64(minPts) {
+				c = 1
 			}
+			atomic.StoreInt32(&state.core[i], c)
+		}
+		return c == 1
+	}
 
-			// Intersection Rect is rect + epsilon
-			adjustedRect := current.Rect.Expand(epsilon)
-			if rectIntersect(current.Rect, adjustedRect) {
-				neighbor := clusters[i]
-				// Merge with neighbors if any point is within epsilon distance
-				for _, p := range current.points {
-					for _, p2 := range neighbor.points {
-						if p.Point.Distance(*p2.Point) <= epsilon {
-							// fmt.Println("Merging")
-							current.points = append(current.points, neighbor.points...)
-							current.Rect = current.Rect.Merge(neighbor.Rect)
-							// Delete merged neighbor
-							clusters = append(clusters[:i], clusters[i+1:]...)
-							goto start
-						}
+	found := 0
+	stack := []*partitionNode{job.node}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node.left != nil {
+			stack = append(stack, node.left, node.right)
+			continue
+		}
+		for _, i := range node.items {
+			if ctx.Err() != nil {
+				return found
+			}
+			core := isCore(i)
+			set := int32(i)
+			closest, closestDist := -1, 0.0
+			for _, n := range tree.Radius(tree.points[i], epsilon) {
+				if n == i || !isCore(n) {
+					continue
+				}
+				if !core { // Border point, attached to the closest core point (the first one on ties)
+					if d := tree.points[i].Distance(tree.points[n]); closest == -1 || d < closestDist || d == closestDist && n < closest {
+						closest, closestDist = n, d
 					}
+					continue
+				}
+				if atomic.LoadInt32(&state.parent[n]) == set {
+					continue
 				}
+				state.union(set, int32(n))
+				set = state.find(set)
+			}
+			if closest != -1 {
+				atomic.StoreInt32(&state.border[i], int32(closest)+1)
+			}
+			if core {
+				found++
 			}
 		}
-		index++
 	}
-	return clusters
+	return found
 }
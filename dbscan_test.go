@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"dbscan/evaluate"
+	"dbscan/generate"
+)
+
+// Checks that a labeling is a valid DBSCAN result for the points
+// Border points may go to any cluster with a core point within epsilon, the rest has to match exactly
+func checkDBSCANLabels(t *testing.T, points []PointN, labels []int, expected []int, epsilon float64, minPts int) {
+	t.Helper()
+	core := make([]bool, len(points))
+	for i, p := range points {
+		n := 0
+		for _, q := range points {
+			if p.Distance(q) <= epsilon {
+				n++
+			}
+		}
+		core[i] = n >= minPts
+	}
+
+	// Core points define the clusters, the ids can be different
+	mapping := make(map[int]int)
+	reverse := make(map[int]int)
+	for i := range points {
+		if !core[i] {
+			continue
+		}
+		if labels[i] == 0 {
+			t.Fatalf("Core point %d is noise", i)
+		}
+		if m, ok := mapping[labels[i]]; ok && m != expected[i] {
+			t.Fatalf("Cluster %d is split in the expected result", labels[i])
+		}
+		if r, ok := reverse[expected[i]]; ok && r != labels[i] {
+			t.Fatalf("Expected cluster %d is split", expected[i])
+		}
+		mapping[labels[i]] = expected[i]
+		reverse[expected[i]] = labels[i]
+	}
+
+	for i, p := range points {
+		if core[i] || labels[i] == 0 && expected[i] == 0 {
+			continue
+		}
+		if (labels[i] == 0) != (expected[i] == 0) {
+			t.Fatalf("Point %d has label %d instead of %d", i, labels[i], expected[i])
+		}
+		attached := false
+		for j, q := range points {
+			attached = attached || core[j] && labels[j] == labels[i] && p.Distance(q) <= epsilon
+		}
+		if !attached {
+			t.Fatalf("Border point %d is not next to a core point of cluster %d", i, labels[i])
+		}
+	}
+}
+
+func TestDBSCANN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := make([]PointN, 500)
+	flat := make([]PointN, len(points)) // Same points with a third dimension that is always 0
+	for i := range points {
+		points[i] = PointN{float64(rng.Intn(60)), float64(rng.Intn(60))}
+		flat[i] = PointN{points[i][0], points[i][1], 0}
+	}
+
+	for _, minPts := range []int{1, 3, 6} {
+		fast := dbscanN(points, nil, 3, minPts, 2)
+		slow := dbscanN(flat, nil, 3, minPts, 2)
+		checkDBSCANLabels(t, points, fast, slow, 3, minPts)
+		checkDBSCANLabels(t, flat, slow, fast, 3, minPts)
+	}
+
+	// Every point of two far away groups
+	groups := []PointN{{0, 0, 0}, {0, 1, 0}, {1, 0, 1}, {50, 50, 50}, {50, 51, 50}, {51, 50, 50}, {100, 0, 0}}
+	labels := dbscanN(groups, nil, 1.5, 3, 2)
+	if labels[0] == 0 || labels[0] != labels[1] || labels[0] != labels[2] || labels[3] == labels[0] ||
+		labels[3] != labels[4] || labels[3] != labels[5] || labels[6] != 0 {
+		t.Errorf("Wrong labels: %v", labels)
+	}
+}
+
+func TestDBSCANNJobs(t *testing.T) {
+	// Enough points for the partition tree to be split into jobs
+	rng := rand.New(rand.NewSource(2))
+	points := make([]PointN, 3_000)
+	for i := range points {
+		points[i] = PointN{rng.NormFloat64() * 3, rng.NormFloat64() * 3, float64(rng.Intn(3)) * 10}
+	}
+	expected := dbscanLabels(len(points), nil, func(i int) []int {
+		neighbors := []int{}
+		for j := range points {
+			if points[i].Distance(points[j]) <= 0.5 {
+				neighbors = append(neighbors, j)
+			}
+		}
+		return neighbors
+	}, 4)
+	for _, nWorkers := range []int{1, 4} {
+		checkDBSCANLabels(t, points, dbscanN(points, nil, 0.5, 4, nWorkers), expected, 0.5, 4)
+	}
+}
+
+func TestDBSCANWeights(t *testing.T) {
+	// A single heavy point is dense enough on its own, a group of light points is not
+	points := []PointN{{0, 0}, {0.5, 0}, {10, 0}, {10.5, 0}, {10, 0.5}}
+	weights := []float64{4, 1, 0.5, 0.5, 0.5}
+	labels := dbscanN(points, weights, 1, 5, 2)
+	if labels[0] == 0 || labels[1] != labels[0] {
+		t.Errorf("Heavy point is not a cluster: %v", labels)
+	}
+	if labels[2] != 0 || labels[3] != 0 || labels[4] != 0 {
+		t.Errorf("Light points are not noise: %v", labels)
+	}
+
+	// Without weights it is the other way around
+	labels = dbscanN(points, nil, 1, 3, 2)
+	if labels[0] != 0 || labels[2] == 0 {
+		t.Errorf("Unweighted labels are not correct: %v", labels)
+	}
+}
+
+func TestClusterWeight(t *testing.T) {
+	a, b := Point{0, 0}, Point{4, 0}
+	c := Cluster{points: []BSPTreePoint{{&a, 2, 3, 0}, {&b, 1, 1, 1}}}
+	if c.Size() != 3 || c.Weight() != 4 {
+		t.Errorf("Wrong size %d or weight %f", c.Size(), c.Weight())
+	}
+	if avg := c.Average(); avg.x != 1 || avg.y != 0 {
+		t.Errorf("Average is not weighted: %v", avg)
+	}
+}
+
+// O(n^2) reference of what the engine computes: textbook DBSCAN on the locations, where a location is a
+// core point if the weight of the locations within epsilon (itself included) is at least minPts.
+// Returns the cluster of every location, starting at 1, 0 means noise.
+func referenceClusters(points []BSPTreePoint, epsilon float64, minPts int) []int {
+	weights := make([]float64, len(points))
+	for i, p := range points {
+		weights[i] = p.weight
+	}
+	return dbscanLabels(len(points), weights, func(i int) []int {
+		neighbors := []int{}
+		for j := range points {
+			if points[i].Point.Distance(*points[j].Point) <= epsilon {
+				neighbors = append(neighbors, j)
+			}
+		}
+		return neighbors
+	}, minPts)
+}
+
+// Checks that the clusters are a valid DBSCAN result (up to the cluster ids)
+// Core points and noise have to match exactly, border points may go to any cluster with a core point within epsilon.
+func checkClusters(t *testing.T, points []BSPTreePoint, clusters []Cluster, expected []int, epsilon float64, minPts int) bool {
+	t.Helper()
+	index := make(map[*Point]int, len(points))
+	for i, p := range points {
+		index[p.Point] = i
+	}
+
+	labels := make([]int, len(points))
+	for c, cluster := range clusters {
+		for _, p := range cluster.points {
+			i, ok := index[p.Point]
+			if !ok {
+				t.Errorf("Cluster %d has an unknown point %v", c, *p.Point)
+				return false
+			}
+			if labels[i] != 0 {
+				t.Errorf("Point %v is in clusters %d and %d", *p.Point, labels[i]-1, c)
+				return false
+			}
+			labels[i] = c + 1
+		}
+	}
+
+	core := make([]bool, len(points))
+	for i, p := range points {
+		weight := 0.0
+		for _, q := range points {
+			if p.Point.Distance(*q.Point) <= epsilon {
+				weight += q.weight
+			}
+		}
+		core[i] = weight >= float64(minPts)
+	}
+
+	// Core points define the clusters
+	mapping := make(map[int]int)
+	reverse := make(map[int]int)
+	for i := range points {
+		if (labels[i] == 0) != (expected[i] == 0) {
+			t.Errorf("Point %v has cluster %d instead of %d", *points[i].Point, labels[i], expected[i])
+			return false
+		}
+		if !core[i] {
+			continue
+		}
+		if m, ok := mapping[labels[i]]; ok && m != expected[i] {
+			t.Errorf("Cluster %d should be split", labels[i]-1)
+			return false
+		}
+		if r, ok := reverse[expected[i]]; ok && r != labels[i] {
+			t.Errorf("Clusters %d and %d should be merged", labels[i]-1, r-1)
+			return false
+		}
+		mapping[labels[i]] = expected[i]
+		reverse[expected[i]] = labels[i]
+	}
+
+	for i, p := range points {
+		if core[i] || labels[i] == 0 {
+			continue
+		}
+		attached := false
+		for j, q := range points {
+			attached = attached || core[j] && labels[j] == labels[i] && p.Point.Distance(*q.Point) <= epsilon
+		}
+		if !attached {
+			t.Errorf("Border point %v is not next to a core point of cluster %d", *p.Point, labels[i]-1)
+			return false
+		}
+	}
+	return true
+}
+
+// Random dataset: blobs of random sizes and spreads, uniform noise and repeated locations
+func randomDataset(rng *rand.Rand, n int) ([]Point, []float64) {
+	points := make([]Point, 0, n)
+	weights := make([]float64, 0, n)
+	for len(points) < n {
+		switch rng.Intn(4) {
+		case 0: // Blob
+			cx, cy, spread := rng.Float64()*100, rng.Float64()*100, rng.Float64()*5
+			for k := rng.Intn(50); k > 0 && len(points) < n; k-- {
+				points = append(points, Point{cx + rng.NormFloat64()*spread, cy + rng.NormFloat64()*spread})
+				weights = append(weights, 1)
+			}
+		case 1: // Repeated location
+			if len(points) > 0 {
+				points = append(points, points[rng.Intn(len(points))])
+				weights = append(weights, 0.5)
+			}
+		default: // Noise
+			points = append(points, Point{rng.Float64() * 100, rng.Float64() * 100})
+			weights = append(weights, float64(rng.Intn(3)))
+		}
+	}
+	return points, weights
+}
+
+// Runs the engine and compares it with the reference
+func checkEngine(t *testing.T, points []Point, weights []float64, epsilon float64, minPts int, maxJobSize int, nWorkers int) bool {
+	t.Helper()
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+	locations := bsp.Query(bsp.rect)
+	clusters, _ := dbscanClusters(context.Background(), bsp, nil, epsilon, minPts, maxJobSize, nWorkers, nil)
+	if !checkClusters(t, locations, clusters, referenceClusters(locations, epsilon, minPts), epsilon, minPts) {
+		t.Logf("%d points, epsilon %f, minPts %d, maxJobSize %d, %d workers", len(points), epsilon, minPts, maxJobSize, nWorkers)
+		return false
+	}
+	return true
+}
+
+func TestEngineMatchesReference(t *testing.T) {
+	// Grid with neighbors at exactly epsilon, a line crossing every job and a single point
+	grid := []Point{}
+	for i := 0; i < 400; i++ {
+		grid = append(grid, Point{float64(i % 20), float64(i / 20)})
+	}
+	line := []Point{}
+	for i := 0; i < 200; i++ {
+		line = append(line, Point{float64(i) * 0.9, float64(i) * 0.1})
+	}
+	random, weights := randomDataset(rand.New(rand.NewSource(1)), 1_000)
+	g := generate.New(1)
+	moons := generatedPoints(g.Moons(500, 0.05))
+	density := generatedPoints(generate.Merge(g.VaryingDensity(500, 4, 0.01), g.Uniform(100)))
+
+	datasets := []struct {
+		name    string
+		points  []Point
+		weights []float64
+		epsilon float64
+	}{
+		{"grid", grid, nil, 1},
+		{"line", line, nil, 1},
+		{"single", []Point{{3, 4}}, nil, 1},
+		{"random", random, weights, 2},
+		{"moons", moons, nil, 0.03},
+		{"density", density, nil, 0.01},
+	}
+	for _, d := range datasets {
+		for _, nWorkers := range []int{1, 2, 3, 8} {
+			for _, maxJobSize := range []int{1, 2, 7, 50, 100_000} {
+				for _, minPts := range []int{1, 3, 10} {
+					if !checkEngine(t, d.points, d.weights, d.epsilon, minPts, maxJobSize, nWorkers) {
+						t.Fatalf("Dataset %s does not match the reference", d.name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEngineMatchesReferenceRandom(t *testing.T) {
+	property := func(seed int64) bool {
+		rng := rand.New(rand.NewSource(seed))
+		points, weights := randomDataset(rng, 1+rng.Intn(400))
+		if rng.Intn(2) == 0 {
+			weights = nil
+		}
+		epsilon := 0.1 + rng.Float64()*4
+		return checkEngine(t, points, weights, epsilon, 1+rng.Intn(10), 1+rng.Intn(200), 1+rng.Intn(8))
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 100}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Waits for the goroutines to go back to at most n
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	for start := time.Now(); runtime.NumGoroutine() > n; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d goroutines are still running instead of %d", runtime.NumGoroutine(), n)
+		}
+	}
+}
+
+func TestDBSCANParallelCancel(t *testing.T) {
+	points := benchmarkPoints(20_000)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	before := runtime.NumGoroutine()
+
+	// Cancelled after a few jobs, every goroutine stops and the state holds the part that was processed
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := 0
+	reporter := newProgressReporter(func(p Progress) {
+		if jobs = p.JobsDone; jobs == 10 {
+			cancel()
+		}
+	})
+	partial := dbscanParallel(ctx, bsp, nil, 0.002, 1, 50, 4, reporter).clusters(bsp, nil)
+	cancel()
+	waitGoroutines(t, before)
+
+	all := dbscanParallel(context.Background(), bsp, nil, 0.002, 1, 50, 4, nil).clusters(bsp, nil)
+	if len(partial) >= len(all) || jobs > 10+4 {
+		t.Errorf("Cancelled run found %d clusters out of %d after %d jobs", len(partial), len(all), jobs)
+	}
+}
+
+func TestDBSCANClustersCancel(t *testing.T) {
+	points := benchmarkPoints(20_000)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clusters, err := dbscanClusters(ctx, bsp, nil, 0.002, 1, 50, 4, nil)
+	if err != context.Canceled {
+		t.Errorf("Got error %v instead of %v", err, context.Canceled)
+	}
+	if len(clusters) != 0 {
+		t.Errorf("Found %d clusters after cancelling", len(clusters))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	partial, err := dbscanClusters(ctx, bsp, nil, 0.002, 1, 50, 1, nil)
+	complete, _ := dbscanClusters(context.Background(), bsp, nil, 0.002, 1, 50, 1, nil)
+	if err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Errorf("Got error %v after %v", err, time.Since(start))
+	}
+	if len(partial) >= len(complete) {
+		t.Errorf("Timed out run found %d clusters out of %d", len(partial), len(complete))
+	}
+
+	if clusters, err := dbscanClusters(context.Background(), bsp, nil, 0.002, 1, 50, 4, nil); err != nil || len(clusters) != len(complete) {
+		t.Errorf("Got %d clusters and error %v without a deadline", len(clusters), err)
+	}
+}
+
+func generatedPoints(d generate.Dataset) []Point {
+	points := make([]Point, len(d.Points))
+	for i, p := range d.Points {
+		points[i] = Point{p.X, p.Y}
+	}
+	return points
+}
+
+func TestEngineFindsGeneratedClusters(t *testing.T) {
+	d := generate.New(3).Rings(3_000, 3, 0.01)
+	points := generatedPoints(d)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	clusters, _ := dbscanClusters(context.Background(), bsp, nil, 0.03, 5, 100, 4, nil)
+
+	// Cluster of every generated point
+	label := make(map[Point]int)
+	for c, cluster := range clusters {
+		for _, p := range cluster.points {
+			label[*p.Point] = c + 1
+		}
+	}
+	labels := make([]int, len(points))
+	for i, p := range points {
+		labels[i] = label[p]
+	}
+	if ari := evaluate.AdjustedRandIndex(d.Labels, labels); ari < 0.99 {
+		t.Errorf("Rings were not found, ARI is %f with %d clusters", ari, len(clusters))
+	}
+}
+
+func TestDBSCANStateUnion(t *testing.T) {
+	s := newDBSCANState(8)
+	s.union(0, 1)
+	s.union(3, 2)
+	s.union(2, 1)
+	s.union(5, 6)
+	if s.find(3) != s.find(0) || s.find(5) != s.find(6) || s.find(0) == s.find(5) || s.find(4) != 4 {
+		t.Errorf("Wrong sets: %v", s.parent)
+	}
+	if s.find(3) != 0 { // The smaller root wins
+		t.Errorf("Root of the first set is %d instead of 0", s.find(3))
+	}
+
+	// Concurrent unions of a chain end up in a single set
+	s = newDBSCANState(10_000)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			for i := w; i < len(s.parent)-1; i += 8 {
+				s.union(int32(i+1), int32(i))
+			}
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+	for i := range s.parent {
+		if s.find(int32(i)) != 0 {
+			t.Fatalf("Location %d is in set %d instead of 0", i, s.find(int32(i)))
+		}
+	}
+}
+
+func TestDBSCANStateClusters(t *testing.T) {
+	// Two core points in the same set with a border point each, a lone core point and noise
+	points := []Point{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {10, 10}, {20, 20}}
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	s := newDBSCANState(bsp.IDs())
+	copy(s.core, []int32{2, 1, 1, 2, 1, 2})
+	s.union(1, 2)
+	s.border[0], s.border[3] = 2, 3
+
+	clusters := s.clusters(bsp, nil)
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %v", clusters)
+	}
+	sizes := map[int]bool{clusters[0].Size(): true, clusters[1].Size(): true}
+	if !sizes[4] || !sizes[1] {
+		t.Errorf("Expected clusters of 4 and 1 points, got %d and %d", clusters[0].Size(), clusters[1].Size())
+	}
+	for _, c := range clusters {
+		if c.Size() == 4 && c.Rect != (Rect{0, 0, 3, 0}) {
+			t.Errorf("Wrong cluster rect: %v", c.Rect)
+		}
+	}
+}
+
+// A chain of points with a gap in the density: only one side has enough neighbors
+// The engine has to see the neighbors of the other jobs to decide, whatever the job size.
+func TestEngineHaloDensity(t *testing.T) {
+	points := []Point{}
+	for i := 0; i < 40; i++ {
+		points = append(points, Point{float64(i), 0})
+		if i < 20 { // Dense half
+			points = append(points, Point{float64(i), 0.5})
+		}
+	}
+	for _, maxJobSize := range []int{1, 3, 10, 0} {
+		for _, nWorkers := range []int{1, 4} {
+			checkEngine(t, points, nil, 1, 4, maxJobSize, nWorkers)
+		}
+	}
+}
+
+func BenchmarkDBSCAN(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+		epsilon := benchmarkEpsilon(n)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				dbscan(context.Background(), bsp, bsp, newDBSCANState(bsp.IDs()), epsilon, 5)
+			}
+		})
+	}
+}
+
+func BenchmarkDBSCANParallel(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+		epsilon := benchmarkEpsilon(n)
+		for _, nWorkers := range []int{1, 2, 4, 8} {
+			for _, maxJobSize := range []int{0, 1_000} { // Scheduler sized jobs and fixed size jobs
+				b.Run(fmt.Sprint("n=", n, "/threads=", nWorkers, "/maxJobSize=", maxJobSize), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						dbscanParallel(context.Background(), bsp, nil, epsilon, 5, maxJobSize, nWorkers, nil)
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkDBSCANStateClusters(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		points := benchmarkPoints(n)
+		bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+		state := dbscanParallel(context.Background(), bsp, nil, benchmarkEpsilon(n), 5, 0, 4, nil)
+		b.Run(fmt.Sprint("n=", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				state.clusters(bsp, nil)
+			}
+		})
+	}
+}
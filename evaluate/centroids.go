@@ -0,0 +1,77 @@
+package evaluate
+
+import "math"
+
+// DaviesBouldin is the mean, over the clusters, of the worst ratio between the spread of two clusters
+// and the distance between their centroids. 0 is best, lower means compact and well separated clusters.
+// Returns NaN if there are less than 2 clusters.
+func DaviesBouldin(points [][]float64, labels []int) float64 {
+	clusters := groups(labels)
+	if len(clusters) < 2 {
+		return math.NaN()
+	}
+
+	centroids := make([][]float64, len(clusters))
+	spread := make([]float64, len(clusters)) // Mean distance to the centroid
+	for c, members := range clusters {
+		centroids[c] = centroid(points, members)
+		for _, i := range members {
+			spread[c] += distance(points[i], centroids[c])
+		}
+		spread[c] /= float64(len(members))
+	}
+
+	total := 0.0
+	for a := range clusters {
+		worst := 0.0
+		for b := range clusters {
+			if a == b {
+				continue
+			}
+			d := distance(centroids[a], centroids[b])
+			if d == 0 {
+				worst = math.Inf(1)
+				break
+			}
+			worst = math.Max(worst, (spread[a]+spread[b])/d)
+		}
+		total += worst
+	}
+	return total / float64(len(clusters))
+}
+
+// CalinskiHarabasz is the ratio between the dispersion between clusters and within clusters,
+// each divided by its degrees of freedom. Higher means denser and better separated clusters.
+// Returns NaN if there are less than 2 clusters or only one point per cluster,
+// and 1 if the points of every cluster are all in the same location (like scikit-learn).
+func CalinskiHarabasz(points [][]float64, labels []int) float64 {
+	clusters := groups(labels)
+	if len(clusters) < 2 {
+		return math.NaN()
+	}
+
+	all := []int{}
+	for _, members := range clusters {
+		all = append(all, members...)
+	}
+	if len(all) == len(clusters) {
+		return math.NaN() // No degrees of freedom left within the clusters
+	}
+	center := centroid(points, all)
+
+	between, within := 0.0, 0.0
+	for _, members := range clusters {
+		c := centroid(points, members)
+		d := distance(c, center)
+		between += float64(len(members)) * d * d
+		for _, i := range members {
+			d := distance(points[i], c)
+			within += d * d
+		}
+	}
+	if within == 0 {
+		return 1
+	}
+	k, n := float64(len(clusters)), float64(len(all))
+	return between * (n - k) / (within * (k - 1))
+}
@@ -0,0 +1,34 @@
+package evaluate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDaviesBouldin(t *testing.T) {
+	points := [][]float64{{0, 0}, {0, 2}, {10, 0}, {10, 2}, {50, 50}}
+	labels := []int{1, 1, 2, 2, 0}
+	if db := DaviesBouldin(points, labels); math.Abs(db-0.2) > 1e-9 {
+		t.Errorf("Davies-Bouldin is %f instead of 0.2", db)
+	}
+	if db := DaviesBouldin(points, []int{1, 2, 1, 2, 0}); db <= 0.2 {
+		t.Errorf("Davies-Bouldin of bad clusters is %f", db)
+	}
+	if db := DaviesBouldin(points, []int{1, 1, 1, 1, 0}); !math.IsNaN(db) {
+		t.Errorf("Davies-Bouldin of a single cluster is %f", db)
+	}
+}
+
+func TestCalinskiHarabasz(t *testing.T) {
+	points := [][]float64{{0, 0}, {0, 2}, {10, 0}, {10, 2}, {50, 50}}
+	labels := []int{1, 1, 2, 2, 0}
+	if ch := CalinskiHarabasz(points, labels); math.Abs(ch-50) > 1e-9 {
+		t.Errorf("Calinski-Harabasz is %f instead of 50", ch)
+	}
+	if ch := CalinskiHarabasz(points, []int{1, 2, 1, 2, 0}); ch >= 50 {
+		t.Errorf("Calinski-Harabasz of bad clusters is %f", ch)
+	}
+	if ch := CalinskiHarabasz([][]float64{{0, 0}, {0, 0}, {1, 1}, {1, 1}}, []int{1, 1, 2, 2}); ch != 1 {
+		t.Errorf("Calinski-Harabasz without dispersion is %f", ch)
+	}
+}
@@ -0,0 +1,257 @@
+package evaluate
+
+import (
+	"math"
+	"sort"
+)
+
+// Contingency counts the points in every pair of clusters of two labelings of the same points
+// Rows are the labels of a and columns the labels of b, both sorted (noise included as 0).
+type Contingency struct {
+	RowLabels []int
+	ColLabels []int
+	Counts    [][]int
+}
+
+func NewContingency(a, b []int) Contingency {
+	rows, cols := sortedLabels(a), sortedLabels(b)
+	rowIndex, colIndex := indexOf(rows), indexOf(cols)
+	counts := make([][]int, len(rows))
+	for r := range counts {
+		counts[r] = make([]int, len(cols))
+	}
+	for i := range a {
+		counts[rowIndex[a[i]]][colIndex[b[i]]]++
+	}
+	return Contingency{rows, cols, counts}
+}
+
+func sortedLabels(labels []int) []int {
+	seen := make(map[int]bool)
+	res := []int{}
+	for _, label := range labels {
+		if !seen[label] {
+			seen[label] = true
+			res = append(res, label)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func indexOf(labels []int) map[int]int {
+	index := make(map[int]int, len(labels))
+	for i, label := range labels {
+		index[label] = i
+	}
+	return index
+}
+
+// Row and column totals
+func (c Contingency) sums() (rows []int, cols []int, n int) {
+	rows, cols = make([]int, len(c.RowLabels)), make([]int, len(c.ColLabels))
+	for r, row := range c.Counts {
+		for k, count := range row {
+			rows[r] += count
+			cols[k] += count
+			n += count
+		}
+	}
+	return rows, cols, n
+}
+
+func pairs(n int) float64 {
+	return float64(n) * float64(n-1) / 2
+}
+
+// AdjustedRandIndex is the share of pairs of points both labelings agree on (same cluster or not),
+// corrected for chance: 1 for identical clusterings (up to the cluster ids), around 0 for random ones.
+// Noise is treated as a cluster of its own.
+func AdjustedRandIndex(a, b []int) float64 {
+	c := NewContingency(a, b)
+	rows, cols, n := c.sums()
+	// Both are a single cluster, or every point is alone: identical, but there is nothing to correct for chance
+	// (like scikit-learn). Also covers n < 2, where there are no pairs at all.
+	if len(rows) == len(cols) && (len(rows) <= 1 || len(rows) == n) {
+		return 1
+	}
+	index, rowPairs, colPairs := 0.0, 0.0, 0.0
+	for _, row := range c.Counts {
+		for _, count := range row {
+			index += pairs(count)
+		}
+	}
+	for _, r := range rows {
+		rowPairs += pairs(r)
+	}
+	for _, k := range cols {
+		colPairs += pairs(k)
+	}
+
+	expected := rowPairs * colPairs / pairs(n)
+	maximum := (rowPairs + colPairs) / 2
+	return (index - expected) / (maximum - expected)
+}
+
+// NMI is the mutual information of the two labelings divided by the mean of their entropies:
+// 1 for identical clusterings (up to the cluster ids), 0 for independent ones.
+// Noise is treated as a cluster of its own.
+func NMI(a, b []int) float64 {
+	c := NewContingency(a, b)
+	rows, cols, n := c.sums()
+	if n == 0 {
+		return math.NaN()
+	}
+	total := float64(n)
+
+	entropy := func(sizes []int) float64 {
+		h := 0.0
+		for _, size := range sizes {
+			if size > 0 {
+				p := float64(size) / total
+				h -= p * math.Log(p)
+			}
+		}
+		return h
+	}
+	mi := 0.0
+	for r, row := range c.Counts {
+		for k, count := range row {
+			if count > 0 {
+				p := float64(count) / total
+				mi += p * math.Log(p*total*total/(float64(rows[r])*float64(cols[k])))
+			}
+		}
+	}
+
+	ha, hb := entropy(rows), entropy(cols)
+	if ha == 0 && hb == 0 {
+		return 1 // Both are a single cluster
+	}
+	return math.Max(mi/((ha+hb)/2), 0)
+}
+
+// A pair of matched clusters
+type Match struct {
+	A, B         int // Cluster ids in both labelings
+	SizeA, SizeB int
+	Overlap      int     // Points in both clusters
+	Jaccard      float64 // Overlap / points in either cluster
+}
+
+// MatchClusters pairs every cluster of a with at most one cluster of b so that the total overlap is the
+// largest possible (Hungarian algorithm). Noise is never matched, pairs without overlap are left out.
+// Matches are sorted by overlap, biggest first.
+func MatchClusters(a, b []int) []Match {
+	c := NewContingency(a, b)
+	rows, cols, _ := c.sums()
+
+	// Cost matrix without noise, square, overlaps negated to minimize
+	rowIds, colIds := []int{}, []int{}
+	for r, label := range c.RowLabels {
+		if label != 0 {
+			rowIds = append(rowIds, r)
+		}
+	}
+	for k, label := range c.ColLabels {
+		if label != 0 {
+			colIds = append(colIds, k)
+		}
+	}
+	n := len(rowIds)
+	if len(colIds) > n {
+		n = len(colIds)
+	}
+	cost := make([][]float64, n)
+	for i := range cost {
+		cost[i] = make([]float64, n)
+		for j := range cost[i] {
+			if i < len(rowIds) && j < len(colIds) {
+				cost[i][j] = -float64(c.Counts[rowIds[i]][colIds[j]])
+			}
+		}
+	}
+
+	matches := []Match{}
+	for i, j := range hungarian(cost) {
+		if i >= len(rowIds) || j >= len(colIds) {
+			continue
+		}
+		r, k := rowIds[i], colIds[j]
+		overlap := c.Counts[r][k]
+		if overlap == 0 {
+			continue
+		}
+		matches = append(matches, Match{
+			A:       c.RowLabels[r],
+			B:       c.ColLabels[k],
+			SizeA:   rows[r],
+			SizeB:   cols[k],
+			Overlap: overlap,
+			Jaccard: float64(overlap) / float64(rows[r]+cols[k]-overlap),
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Overlap > matches[j].Overlap
+	})
+	return matches
+}
+
+// Minimum cost assignment of a square matrix (Hungarian algorithm with potentials, O(n^3))
+// Returns the column assigned to every row.
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	u, v := make([]float64, n+1), make([]float64, n+1) // Potentials of rows and columns
+	p := make([]int, n+1)                              // Row assigned to each column, 1 based (0 is none)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for p[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		// Follow the augmenting path back
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] != 0 {
+			assignment[p[j]-1] = j - 1
+		}
+	}
+	return assignment
+}
@@ -0,0 +1,86 @@
+package evaluate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestContingency(t *testing.T) {
+	c := NewContingency([]int{1, 1, 2, 0}, []int{3, 5, 5, 5})
+	if len(c.RowLabels) != 3 || c.RowLabels[0] != 0 || len(c.ColLabels) != 2 || c.ColLabels[1] != 5 {
+		t.Errorf("Wrong labels: %v %v", c.RowLabels, c.ColLabels)
+	}
+	if c.Counts[1][0] != 1 || c.Counts[1][1] != 1 || c.Counts[2][1] != 1 || c.Counts[0][1] != 1 || c.Counts[0][0] != 0 {
+		t.Errorf("Wrong counts: %v", c.Counts)
+	}
+}
+
+func TestAdjustedRandIndex(t *testing.T) {
+	if ari := AdjustedRandIndex([]int{1, 1, 2, 2}, []int{2, 2, 1, 1}); ari != 1 {
+		t.Errorf("ARI of renamed clusters is %f", ari)
+	}
+	// Same values as scikit-learn
+	if ari := AdjustedRandIndex([]int{0, 0, 1, 1}, []int{0, 0, 1, 2}); math.Abs(ari-0.5714285714285715) > 1e-12 {
+		t.Errorf("ARI is %f instead of 0.571", ari)
+	}
+	if ari := AdjustedRandIndex([]int{0, 0, 0, 0}, []int{0, 1, 2, 3}); ari != 0 {
+		t.Errorf("ARI of unrelated clusters is %f", ari)
+	}
+
+	// Nothing to correct for chance, identical labelings still score 1 (like scikit-learn)
+	for _, labels := range [][]int{{}, {3}, {0, 0, 0}, {1, 2, 3, 4}} {
+		if ari := AdjustedRandIndex(labels, labels); ari != 1 {
+			t.Errorf("ARI of %v with itself is %f", labels, ari)
+		}
+	}
+	if ari := AdjustedRandIndex([]int{5, 5, 5}, []int{0, 0, 0}); ari != 1 {
+		t.Errorf("ARI of two single clusters is %f", ari)
+	}
+}
+
+func TestNMI(t *testing.T) {
+	if nmi := NMI([]int{1, 1, 2, 2}, []int{2, 2, 1, 1}); math.Abs(nmi-1) > 1e-12 {
+		t.Errorf("NMI of renamed clusters is %f", nmi)
+	}
+	if nmi := NMI([]int{0, 0, 1, 1}, []int{0, 0, 1, 2}); math.Abs(nmi-0.8) > 1e-12 {
+		t.Errorf("NMI is %f instead of 0.8", nmi)
+	}
+	if nmi := NMI([]int{1, 1, 2, 2}, []int{1, 2, 1, 2}); math.Abs(nmi) > 1e-12 {
+		t.Errorf("NMI of independent clusters is %f", nmi)
+	}
+}
+
+func TestMatchClusters(t *testing.T) {
+	// Greedy would match 1 with 10 (overlap 5) and leave 2 alone, 1 with 20 and 2 with 10 overlap 8 in total
+	a := []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 0}
+	b := []int{10, 10, 10, 10, 10, 20, 20, 20, 20, 10, 10, 10, 10, 20}
+	matches := MatchClusters(a, b)
+	if len(matches) != 2 {
+		t.Fatalf("Got %d matches instead of 2: %v", len(matches), matches)
+	}
+	for _, m := range matches {
+		if m.Overlap != 4 || !(m.A == 1 && m.B == 20 || m.A == 2 && m.B == 10) {
+			t.Errorf("Wrong match: %+v", m)
+		}
+		if m.A == 2 && (m.SizeA != 4 || m.SizeB != 9 || math.Abs(m.Jaccard-4.0/9) > 1e-12) {
+			t.Errorf("Wrong sizes: %+v", m)
+		}
+	}
+
+	// Noise is never matched
+	if matches := MatchClusters([]int{0, 0, 1}, []int{0, 0, 1}); len(matches) != 1 || matches[0].A != 1 {
+		t.Errorf("Wrong matches with noise: %v", matches)
+	}
+}
+
+func TestHungarian(t *testing.T) {
+	cost := [][]float64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}
+	assignment := hungarian(cost)
+	total := 0.0
+	for i, j := range assignment {
+		total += cost[i][j]
+	}
+	if total != 5 {
+		t.Errorf("Assignment %v costs %f instead of 5", assignment, total)
+	}
+}
@@ -0,0 +1,156 @@
+package evaluate
+
+import (
+	"math"
+	"sync"
+)
+
+// DBCV (Density-Based Clustering Validation, Moulavi et al. 2014) scores clusters of any shape, which
+// suits density based clusterings better than the centroid scores. For every cluster:
+//   - The core distance of a point depends on the distances to all the other points of its cluster
+//   - The density sparseness is the heaviest edge between internal points of the minimum spanning tree
+//     of the cluster, using the mutual reachability distance max(core(a), core(b), d(a, b))
+//   - The density separation is the smallest mutual reachability distance to the internal points of another cluster
+//
+// The score of a cluster is (separation - sparseness) / max(separation, sparseness), and DBCV is the mean of
+// these scores weighted by the cluster sizes over all the points, so noise points lower it.
+// From -1 to 1, higher is better. It is O(n^2), split between nWorkers threads.
+// Returns NaN if there are less than 2 clusters.
+func DBCV(points [][]float64, labels []int, nWorkers int) float64 {
+	clusters := groups(labels)
+	if len(clusters) < 2 {
+		return math.NaN()
+	}
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	core := make([]float64, len(points))
+	internal := make([][]int, len(clusters))
+	sparseness := make([]float64, len(clusters))
+	parallel(len(clusters), nWorkers, func(c int) {
+		members := clusters[c]
+		for _, i := range members {
+			core[i] = coreDistance(points, members, i)
+		}
+		internal[c], sparseness[c] = clusterMST(points, members, core)
+	})
+
+	separation := make([]float64, len(clusters))
+	parallel(len(clusters), nWorkers, func(c int) {
+		separation[c] = math.Inf(1)
+		for other := range clusters {
+			if other == c {
+				continue
+			}
+			for _, i := range internal[c] {
+				for _, j := range internal[other] {
+					d := math.Max(distance(points[i], points[j]), math.Max(core[i], core[j]))
+					separation[c] = math.Min(separation[c], d)
+				}
+			}
+		}
+	})
+
+	total := 0.0
+	for c, members := range clusters {
+		validity := 0.0
+		if m := math.Max(separation[c], sparseness[c]); m > 0 {
+			validity = (separation[c] - sparseness[c]) / m
+		}
+		total += validity * float64(len(members))
+	}
+	return total / float64(len(points))
+}
+
+// All points core distance: the inverse of the mean inverse distance to the other points of the cluster,
+// to the power of the number of dimensions. Duplicates of the point make it 0.
+func coreDistance(points [][]float64, members []int, i int) float64 {
+	if len(members) < 2 {
+		return 0
+	}
+	dims := float64(len(points[i]))
+	sum := 0.0
+	for _, j := range members {
+		if j != i {
+			sum += math.Pow(1/distance(points[i], points[j]), dims)
+		}
+	}
+	return math.Pow(sum/float64(len(members)-1), -1/dims)
+}
+
+// Prim's algorithm over the mutual reachability distances of a cluster
+// Returns the internal points (more than one edge in the tree) and the heaviest edge between two of them.
+// Clusters too small to have internal points use all of their points and edges instead.
+func clusterMST(points [][]float64, members []int, core []float64) ([]int, float64) {
+	n := len(members)
+	inTree := make([]bool, n)
+	best := make([]float64, n)
+	from := make([]int, n)
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+	type edge struct {
+		a, b int
+		dist float64
+	}
+	edges := []edge{}
+	degree := make([]int, n)
+
+	current := 0
+	inTree[0] = true
+	for len(edges) < n-1 {
+		next := -1
+		for k := range members {
+			if inTree[k] {
+				continue
+			}
+			i, j := members[current], members[k]
+			d := math.Max(distance(points[i], points[j]), math.Max(core[i], core[j]))
+			if d < best[k] {
+				best[k] = d
+				from[k] = current
+			}
+			if next == -1 || best[k] < best[next] {
+				next = k
+			}
+		}
+		edges = append(edges, edge{from[next], next, best[next]})
+		degree[from[next]]++
+		degree[next]++
+		inTree[next] = true
+		current = next
+	}
+
+	internal := []int{}
+	for k, i := range members {
+		if degree[k] > 1 {
+			internal = append(internal, i)
+		}
+	}
+	sparseness := 0.0
+	for _, e := range edges {
+		if len(internal) == 0 || degree[e.a] > 1 && degree[e.b] > 1 {
+			sparseness = math.Max(sparseness, e.dist)
+		}
+	}
+	if len(internal) == 0 {
+		internal = members
+	}
+	return internal, sparseness
+}
+
+// Runs f for 0 to n-1, split between nWorkers threads
+func parallel(n int, nWorkers int, f func(i int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			for i := w; i < n; i += nWorkers {
+				f(i)
+			}
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+}
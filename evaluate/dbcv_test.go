@@ -0,0 +1,61 @@
+package evaluate
+
+import (
+	"math"
+	"testing"
+)
+
+// Two parallel lines of points, 1 apart along the line and gap apart from each other
+func lines(n int, gap float64) ([][]float64, []int) {
+	points := [][]float64{}
+	labels := []int{}
+	for i := 0; i < n; i++ {
+		points = append(points, []float64{float64(i), 0}, []float64{float64(i), gap})
+		labels = append(labels, 1, 2)
+	}
+	return points, labels
+}
+
+func TestDBCV(t *testing.T) {
+	points, labels := lines(20, 50)
+	far := DBCV(points, labels, 1)
+	if far < 0.9 || far > 1 {
+		t.Errorf("DBCV of well separated lines is %f", far)
+	}
+	if again := DBCV(points, labels, 4); math.Abs(again-far) > 1e-12 {
+		t.Errorf("DBCV with 4 workers is %f instead of %f", again, far)
+	}
+
+	// Lines closer than their own spacing are not separated by density
+	points, labels = lines(20, 0.5)
+	if close := DBCV(points, labels, 2); close >= 0 {
+		t.Errorf("DBCV of overlapping lines is %f", close)
+	}
+
+	// Noise counts against the clustering
+	points, labels = lines(20, 50)
+	points = append(points, []float64{100, 100}, []float64{-100, 100})
+	labels = append(labels, 0, 0)
+	if noisy := DBCV(points, labels, 2); math.Abs(noisy-far*40/42) > 1e-9 {
+		t.Errorf("DBCV with noise is %f instead of %f", noisy, far*40/42)
+	}
+
+	if single := DBCV(points, make([]int, len(points)), 2); !math.IsNaN(single) {
+		t.Errorf("DBCV without clusters is %f", single)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	points, labels := lines(20, 50)
+	points = append(points, []float64{100, 100})
+	labels = append(labels, 0)
+
+	scores := Evaluate(points, labels, 0, 1, 2)
+	if scores.Clusters != 2 || scores.Noise != 1 {
+		t.Errorf("Wrong counts: %+v", scores)
+	}
+	if scores.Silhouette != Silhouette(points, labels, 0, 1, 2) || scores.DaviesBouldin != DaviesBouldin(points, labels) ||
+		scores.CalinskiHarabasz != CalinskiHarabasz(points, labels) || scores.DBCV != DBCV(points, labels, 2) {
+		t.Errorf("Scores do not match the single metrics: %+v", scores)
+	}
+}
@@ -0,0 +1,112 @@
+// Package evaluate scores clusterings without knowing the true clusters.
+// Points are given as coordinates and labels follow the convention of the clusterers:
+// clusters start at 1 and 0 means noise. Noise points are left out of every score,
+// except DBCV which counts them against the clustering.
+package evaluate
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Scores of a clustering, NaN when a score is undefined (less than 2 clusters)
+type Scores struct {
+	Clusters         int
+	Noise            int     // Number of noise points
+	Silhouette       float64 // -1 to 1, higher is better
+	DaviesBouldin    float64 // 0 and up, lower is better
+	CalinskiHarabasz float64 // 0 and up, higher is better
+	DBCV             float64 // -1 to 1, higher is better
+}
+
+// Evaluate computes every score of a clustering
+// The O(n^2) scores (silhouette and DBCV) only use sample points picked at random (sample <= 0 for all of them),
+// split between nWorkers threads.
+func Evaluate(points [][]float64, labels []int, sample int, seed int64, nWorkers int) Scores {
+	scores := Scores{
+		Silhouette:       Silhouette(points, labels, sample, seed, nWorkers),
+		DaviesBouldin:    DaviesBouldin(points, labels),
+		CalinskiHarabasz: CalinskiHarabasz(points, labels),
+	}
+	ids := make(map[int]bool)
+	for _, label := range labels {
+		if label == 0 {
+			scores.Noise++
+		} else {
+			ids[label] = true
+		}
+	}
+	scores.Clusters = len(ids)
+
+	// DBCV on a sample keeps the same share of noise points
+	if sample > 0 && len(points) > sample {
+		subset := rand.New(rand.NewSource(seed)).Perm(len(points))[:sample]
+		sampled, sampledLabels := make([][]float64, sample), make([]int, sample)
+		for i, j := range subset {
+			sampled[i], sampledLabels[i] = points[j], labels[j]
+		}
+		scores.DBCV = DBCV(sampled, sampledLabels, nWorkers)
+	} else {
+		scores.DBCV = DBCV(points, labels, nWorkers)
+	}
+	return scores
+}
+
+func distance(p, q []float64) float64 {
+	sum := 0.0
+	for d := range p {
+		sum += (p[d] - q[d]) * (p[d] - q[d])
+	}
+	return math.Sqrt(sum)
+}
+
+// Returns the indices of the clustered (non noise) points, at most sample of them picked at random
+// (sample <= 0 keeps all of them). The same seed always picks the same points.
+func clustered(labels []int, sample int, seed int64) []int {
+	indices := []int{}
+	for i, label := range labels {
+		if label != 0 {
+			indices = append(indices, i)
+		}
+	}
+	if sample <= 0 || len(indices) <= sample {
+		return indices
+	}
+	rand.New(rand.NewSource(seed)).Shuffle(len(indices), func(i, j int) {
+		indices[i], indices[j] = indices[j], indices[i]
+	})
+	return indices[:sample]
+}
+
+// Groups the indices of the clustered points by cluster, in order of first appearance
+func groups(labels []int) [][]int {
+	ids := make(map[int]int)
+	res := [][]int{}
+	for i, label := range labels {
+		if label == 0 {
+			continue
+		}
+		id, ok := ids[label]
+		if !ok {
+			id = len(res)
+			ids[label] = id
+			res = append(res, nil)
+		}
+		res[id] = append(res[id], i)
+	}
+	return res
+}
+
+// Mean of the given points
+func centroid(points [][]float64, indices []int) []float64 {
+	c := make([]float64, len(points[indices[0]]))
+	for _, i := range indices {
+		for d, v := range points[i] {
+			c[d] += v
+		}
+	}
+	for d := range c {
+		c[d] /= float64(len(indices))
+	}
+	return c
+}
@@ -0,0 +1,75 @@
+package evaluate
+
+import (
+	"math"
+	"sync"
+)
+
+// Silhouette is the mean silhouette coefficient of the clustered points, from -1 (wrong clusters)
+// to 1 (compact and well separated clusters). For every point, a is the mean distance to the rest
+// of its cluster and b the mean distance to the closest other cluster, its coefficient is (b - a) / max(a, b).
+//
+// It is O(n^2), so only sample points picked at random are scored (sample <= 0 scores all of them),
+// split between nWorkers threads. Returns NaN if there are less than 2 clusters.
+func Silhouette(points [][]float64, labels []int, sample int, seed int64, nWorkers int) float64 {
+	indices := clustered(labels, sample, seed)
+
+	// Clusters as consecutive ids
+	ids := make(map[int]int)
+	for _, i := range indices {
+		if _, ok := ids[labels[i]]; !ok {
+			ids[labels[i]] = len(ids)
+		}
+	}
+	if len(ids) < 2 {
+		return math.NaN()
+	}
+	size := make([]int, len(ids))
+	for _, i := range indices {
+		size[ids[labels[i]]]++
+	}
+
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+	scores := make([]float64, len(indices))
+	var wg sync.WaitGroup
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			sum := make([]float64, len(ids)) // Distance to every cluster
+			for a := w; a < len(indices); a += nWorkers {
+				i := indices[a]
+				for c := range sum {
+					sum[c] = 0
+				}
+				for _, j := range indices {
+					sum[ids[labels[j]]] += distance(points[i], points[j])
+				}
+
+				own := ids[labels[i]]
+				if size[own] == 1 {
+					continue // A point alone in its cluster scores 0
+				}
+				in := sum[own] / float64(size[own]-1)
+				out := math.Inf(1)
+				for c := range sum {
+					if c != own {
+						out = math.Min(out, sum[c]/float64(size[c]))
+					}
+				}
+				if m := math.Max(in, out); m > 0 {
+					scores[a] = (out - in) / m
+				}
+			}
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0.0
+	for _, s := range scores {
+		total += s
+	}
+	return total / float64(len(scores))
+}
@@ -0,0 +1,47 @@
+package evaluate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSilhouette(t *testing.T) {
+	points := [][]float64{{0, 0}, {0, 1}, {10, 0}, {10, 1}, {5, 5}}
+	labels := []int{1, 1, 2, 2, 0}
+
+	// a = 1, b = mean(10, sqrt(101)) for every point
+	b := (10 + math.Sqrt(101)) / 2
+	expected := (b - 1) / b
+	for _, workers := range []int{1, 3} {
+		if s := Silhouette(points, labels, 0, 1, workers); math.Abs(s-expected) > 1e-9 {
+			t.Errorf("Silhouette with %d workers is %f instead of %f", workers, s, expected)
+		}
+	}
+
+	// Swapping the clusters of two points makes it negative
+	if s := Silhouette(points, []int{1, 2, 2, 1, 0}, 0, 1, 2); s >= 0 {
+		t.Errorf("Silhouette of bad clusters is %f", s)
+	}
+
+	if s := Silhouette(points, []int{1, 1, 1, 1, 0}, 0, 1, 2); !math.IsNaN(s) {
+		t.Errorf("Silhouette of a single cluster is %f", s)
+	}
+}
+
+func TestSilhouetteSample(t *testing.T) {
+	points := [][]float64{}
+	labels := []int{}
+	for i := 0; i < 200; i++ {
+		points = append(points, []float64{float64(i % 10), float64(i/10) + float64(i%2)*100})
+		labels = append(labels, i%2+1)
+	}
+
+	all := Silhouette(points, labels, 0, 1, 4)
+	sampled := Silhouette(points, labels, 50, 1, 4)
+	if math.Abs(all-sampled) > 0.05 {
+		t.Errorf("Sampled silhouette %f is far from %f", sampled, all)
+	}
+	if again := Silhouette(points, labels, 50, 1, 2); again != sampled {
+		t.Errorf("Same seed gave %f and %f", sampled, again)
+	}
+}
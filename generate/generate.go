@@ -0,0 +1,175 @@
+// Package generate makes synthetic datasets with known clusters, to test and benchmark the clusterers
+// without a real data file. Every dataset fits in the unit square and the same seed always gives the same points.
+package generate
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Point is a generated location
+type Point struct {
+	X, Y float64
+}
+
+// Dataset holds generated points and the cluster each one was generated from (starting at 1, 0 for noise)
+type Dataset struct {
+	Points []Point
+	Labels []int
+}
+
+// Number of clusters in the dataset
+func (d Dataset) Clusters() int {
+	clusters := 0
+	for _, label := range d.Labels {
+		if label > clusters {
+			clusters = label
+		}
+	}
+	return clusters
+}
+
+// Maps the unit square to the rect at (x, y) of size w x h
+func (d Dataset) Scale(x, y, w, h float64) Dataset {
+	res := Dataset{make([]Point, len(d.Points)), append([]int{}, d.Labels...)}
+	for i, p := range d.Points {
+		res.Points[i] = Point{x + p.X*w, y + p.Y*h}
+	}
+	return res
+}
+
+// Merge puts datasets together, the clusters of each dataset get new labels after the ones of the previous datasets
+func Merge(datasets ...Dataset) Dataset {
+	res := Dataset{}
+	offset := 0
+	for _, d := range datasets {
+		res.Points = append(res.Points, d.Points...)
+		for _, label := range d.Labels {
+			if label != 0 {
+				label += offset
+			}
+			res.Labels = append(res.Labels, label)
+		}
+		offset += d.Clusters()
+	}
+	return res
+}
+
+// Generator makes datasets from a seeded random source
+type Generator struct {
+	rng *rand.Rand
+}
+
+func New(seed int64) *Generator {
+	return &Generator{rand.New(rand.NewSource(seed))}
+}
+
+// Splits n points between k clusters
+func split(n, k int) []int {
+	sizes := make([]int, k)
+	for c := range sizes {
+		sizes[c] = n / k
+		if c < n%k {
+			sizes[c]++
+		}
+	}
+	return sizes
+}
+
+func (d *Dataset) add(p Point, label int) {
+	d.Points = append(d.Points, p)
+	d.Labels = append(d.Labels, label)
+}
+
+// Random centers for k clusters, at least 8 spreads apart when there is room for it
+func (g *Generator) centers(k int, spread float64) []Point {
+	margin := math.Min(4*spread, 0.25)
+	centers := []Point{}
+	for tries := 0; len(centers) < k; tries++ {
+		c := Point{margin + g.rng.Float64()*(1-2*margin), margin + g.rng.Float64()*(1-2*margin)}
+		far := true
+		for _, other := range centers {
+			far = far && math.Hypot(c.X-other.X, c.Y-other.Y) >= 8*spread
+		}
+		if far || tries > 1_000*k {
+			centers = append(centers, c)
+		}
+	}
+	return centers
+}
+
+// Blobs are k round clusters around random centers, with a normal spread (standard deviation)
+func (g *Generator) Blobs(n, k int, spread float64) Dataset {
+	return g.blobs(n, k, func(c int) float64 { return spread })
+}
+
+// VaryingDensity gives k blobs of the same size, each one spread more than the previous one:
+// cluster c has a spread of spread * (c + 1), so the last cluster is k^2 times sparser than the first.
+func (g *Generator) VaryingDensity(n, k int, spread float64) Dataset {
+	return g.blobs(n, k, func(c int) float64 { return spread * float64(c+1) })
+}
+
+func (g *Generator) blobs(n, k int, spread func(c int) float64) Dataset {
+	d := Dataset{}
+	centers := g.centers(k, spread(k-1))
+	for c, size := range split(n, k) {
+		for i := 0; i < size; i++ {
+			s := spread(c)
+			d.add(Point{centers[c].X + g.rng.NormFloat64()*s, centers[c].Y + g.rng.NormFloat64()*s}, c+1)
+		}
+	}
+	return d
+}
+
+// Moons are two interleaving half circles, with a normal noise (relative to the radius of the circles)
+func (g *Generator) Moons(n int, noise float64) Dataset {
+	d := Dataset{}
+	for c, size := range split(n, 2) {
+		for i := 0; i < size; i++ {
+			t := g.rng.Float64() * math.Pi
+			x, y := math.Cos(t), math.Sin(t)
+			if c == 1 {
+				x, y = 1-x, 0.5-y
+			}
+			x += g.rng.NormFloat64() * noise
+			y += g.rng.NormFloat64() * noise
+
+			// From [-1, 2] x [-0.5, 1] to the middle of the unit square
+			d.add(Point{(x + 1) / 3, (y+0.5)/3 + 0.25}, c+1)
+		}
+	}
+	return d
+}
+
+// Rings are concentric circles around the center of the square, with a normal noise on the radius
+// (relative to the radius of the outer ring)
+func (g *Generator) Rings(n, rings int, noise float64) Dataset {
+	d := Dataset{}
+	for c, size := range split(n, rings) {
+		radius := 0.45 * float64(c+1) / float64(rings)
+		for i := 0; i < size; i++ {
+			t := g.rng.Float64() * 2 * math.Pi
+			r := radius + g.rng.NormFloat64()*noise*0.45
+			d.add(Point{0.5 + r*math.Cos(t), 0.5 + r*math.Sin(t)}, c+1)
+		}
+	}
+	return d
+}
+
+// Uniform noise over the unit square
+func (g *Generator) Uniform(n int) Dataset {
+	d := Dataset{}
+	for i := 0; i < n; i++ {
+		d.add(Point{g.rng.Float64(), g.rng.Float64()}, 0)
+	}
+	return d
+}
+
+// Shuffle puts the points in a random order, so the clusters are not written one after the other
+func (g *Generator) Shuffle(d Dataset) Dataset {
+	res := Dataset{make([]Point, len(d.Points)), make([]int, len(d.Labels))}
+	for i, j := range g.rng.Perm(len(d.Points)) {
+		res.Points[i], res.Labels[i] = d.Points[j], d.Labels[j]
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+package generate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSameSeed(t *testing.T) {
+	a := New(7).Blobs(100, 3, 0.01)
+	b := New(7).Blobs(100, 3, 0.01)
+	c := New(8).Blobs(100, 3, 0.01)
+	for i := range a.Points {
+		if a.Points[i] != b.Points[i] || a.Labels[i] != b.Labels[i] {
+			t.Fatalf("Same seed gave different point %d: %v %v", i, a.Points[i], b.Points[i])
+		}
+	}
+	if a.Points[0] == c.Points[0] {
+		t.Error("Different seeds gave the same points")
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	d := New(1).Blobs(1_000, 4, 0.01)
+	if len(d.Points) != 1_000 || d.Clusters() != 4 {
+		t.Fatalf("Got %d points in %d clusters", len(d.Points), d.Clusters())
+	}
+
+	// Points are close to the mean of their cluster
+	sum := make([]Point, 5)
+	count := make([]int, 5)
+	for i, p := range d.Points {
+		sum[d.Labels[i]].X += p.X
+		sum[d.Labels[i]].Y += p.Y
+		count[d.Labels[i]]++
+	}
+	for i, p := range d.Points {
+		c := sum[d.Labels[i]]
+		n := float64(count[d.Labels[i]])
+		if math.Hypot(p.X-c.X/n, p.Y-c.Y/n) > 0.06 {
+			t.Errorf("Point %v is far from its cluster %d", p, d.Labels[i])
+		}
+	}
+}
+
+func TestVaryingDensity(t *testing.T) {
+	d := New(1).VaryingDensity(3_000, 3, 0.005)
+	spread := make([]float64, 4)
+	mean := make([]Point, 4)
+	for i, p := range d.Points {
+		mean[d.Labels[i]].X += p.X / 1_000
+		mean[d.Labels[i]].Y += p.Y / 1_000
+	}
+	for i, p := range d.Points {
+		m := mean[d.Labels[i]]
+		spread[d.Labels[i]] += ((p.X-m.X)*(p.X-m.X) + (p.Y-m.Y)*(p.Y-m.Y)) / 2_000
+	}
+	for c := 1; c <= 3; c++ {
+		if s := math.Sqrt(spread[c]); math.Abs(s-0.005*float64(c)) > 0.001*float64(c) {
+			t.Errorf("Cluster %d has a spread of %f", c, s)
+		}
+	}
+}
+
+func TestMoonsAndRings(t *testing.T) {
+	for _, d := range []Dataset{New(1).Moons(500, 0), New(1).Rings(500, 3, 0)} {
+		if len(d.Points) != 500 {
+			t.Errorf("Got %d points", len(d.Points))
+		}
+		for _, p := range d.Points {
+			if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+				t.Errorf("Point %v is outside of the unit square", p)
+			}
+		}
+	}
+
+	rings := New(1).Rings(300, 3, 0)
+	for i, p := range rings.Points {
+		r := math.Hypot(p.X-0.5, p.Y-0.5)
+		if math.Abs(r-0.15*float64(rings.Labels[i])) > 1e-9 {
+			t.Errorf("Point %v of ring %d has a radius of %f", p, rings.Labels[i], r)
+		}
+	}
+}
+
+func TestMergeAndScale(t *testing.T) {
+	g := New(1)
+	d := Merge(g.Blobs(10, 2, 0.01), g.Uniform(5), g.Moons(10, 0.05))
+	if len(d.Points) != 25 || d.Clusters() != 4 {
+		t.Fatalf("Got %d points in %d clusters", len(d.Points), d.Clusters())
+	}
+	for i := 10; i < 15; i++ {
+		if d.Labels[i] != 0 {
+			t.Errorf("Noise point %d has label %d", i, d.Labels[i])
+		}
+	}
+	if d.Labels[15] != 3 || d.Labels[24] != 4 {
+		t.Errorf("Moons were not relabeled: %v", d.Labels[15:])
+	}
+
+	scaled := d.Scale(-74, 40, 0.2, 0.1)
+	for i, p := range scaled.Points {
+		if math.Abs(p.X-(-74+d.Points[i].X*0.2)) > 1e-12 || math.Abs(p.Y-(40+d.Points[i].Y*0.1)) > 1e-12 {
+			t.Errorf("Point %v was not scaled", p)
+		}
+	}
+
+	shuffled := g.Shuffle(d)
+	counts := make(map[int]int)
+	for _, label := range shuffled.Labels {
+		counts[label]++
+	}
+	if counts[0] != 5 || counts[1] != 5 || counts[4] != 5 {
+		t.Errorf("Shuffle lost labels: %v", counts)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// GridIndex is a hashed grid of locations: every location is stored in the square cell it falls in,
+// so a query only reads the cells that intersect its square. Only the non-empty cells are stored.
+type GridIndex struct {
+	cell     float64 // Width of the cells
+	cells    map[gridCell][]BSPTreePoint
+	order    []gridCell // Cells in x then y order, for the walks that read every cell
+	size     int
+	ids      int      // Ids handed out so far
+	bounds   Rect     // Holds every location inserted so far, it does not shrink on removal
+	from, to gridCell // Cells of the corners of bounds
+}
+
+type gridCell struct {
+	x, y int64
+}
+
+// Create a new GridIndex with cells of the given width
+func NewGridIndex(cell float64) *GridIndex {
+	if !(cell > 0) || math.IsInf(cell, 0) { // Any width finds the points at the exact same location
+		cell = 1
+	}
+	return &GridIndex{cell: cell, cells: make(map[gridCell][]BSPTreePoint)}
+}
+
+// Create a new GridIndex holding the locations of a tree (with their ids)
+func NewGridIndexFromTree(bsp *BSPTree, cell float64) *GridIndex {
+	g := NewGridIndex(cell)
+	for p := range bsp.Iterate() {
+		g.add(p)
+	}
+	g.ids = bsp.IDs()
+	return g
+}
+
+func (g *GridIndex) cellOf(x, y float64) gridCell {
+	return gridCell{int64(math.Floor(x / g.cell)), int64(math.Floor(y / g.cell))}
+}
+
+// Adds a new location
+func (g *GridIndex) add(p BSPTreePoint) {
+	c := g.cellOf(p.x, p.y)
+	if _, ok := g.cells[c]; !ok {
+		i := g.orderIndex(c)
+		g.order = append(g.order, gridCell{})
+		copy(g.order[i+1:], g.order[i:])
+		g.order[i] = c
+	}
+	g.cells[c] = append(g.cells[c], p)
+	if g.size == 0 {
+		g.bounds, g.from, g.to = Rect{p.x, p.y, 0, 0}, c, c
+	} else {
+		g.bounds = g.bounds.MergePoint(*p.Point)
+		g.from = gridCell{minInt64(g.from.x, c.x), minInt64(g.from.y, c.y)}
+		g.to = gridCell{maxInt64(g.to.x, c.x), maxInt64(g.to.y, c.y)}
+	}
+	g.size += p.cnt
+}
+
+// Location at p, nil if there is none
+func (g *GridIndex) find(p *Point) *BSPTreePoint {
+	cell := g.cells[g.cellOf(p.x, p.y)]
+	for i := range cell {
+		if pointIntersect(*cell[i].Point, *p) {
+			return &cell[i]
+		}
+	}
+	return nil
+}
+
+// Grid insert
+func (g *GridIndex) Insert(p *Point) {
+	if l := g.find(p); l != nil {
+		l.cnt++
+		l.weight++
+		g.size++
+		return
+	}
+	g.add(BSPTreePoint{p, 1, 1, g.ids})
+	g.ids++
+}
+
+// Grid remove, empty cells are dropped
+func (g *GridIndex) Remove(p *Point) bool {
+	l := g.find(p)
+	if l == nil {
+		return false
+	}
+	l.cnt--
+	l.weight--
+	g.size--
+	if l.cnt == 0 {
+		c := g.cellOf(p.x, p.y)
+		cell := g.cells[c]
+		*l = cell[len(cell)-1]
+		if cell = cell[:len(cell)-1]; len(cell) == 0 {
+			delete(g.cells, c)
+			i := g.orderIndex(c)
+			g.order = append(g.order[:i], g.order[i+1:]...)
+		} else {
+			g.cells[c] = cell
+		}
+	}
+	return true
+}
+
+func (g *GridIndex) Size() int {
+	return g.size
+}
+
+func (g *GridIndex) Bounds() Rect {
+	return g.bounds
+}
+
+// Index of c in order, or where it goes if it is not there
+// The order is kept up to date by add and Remove, so that the queries only read it and can run in parallel.
+func (g *GridIndex) orderIndex(c gridCell) int {
+	return sort.Search(len(g.order), func(i int) bool {
+		o := g.order[i]
+		return o.x > c.x || o.x == c.x && o.y >= c.y
+	})
+}
+
+// Calls visit on the locations of every cell intersecting r, in x then y order of the cells, until visit returns false
+// Reads every cell when there are fewer of them than cells in r.
+func (g *GridIndex) walk(r Rect, visit func(n BSPTreePoint) bool) {
+	from, to := g.cellOf(r.x, r.y), g.cellOf(r.x+r.w, r.y+r.h)
+	if float64(to.x-from.x+1)*float64(to.y-from.y+1) > float64(len(g.cells)) {
+		for _, c := range g.order {
+			for _, n := range g.cells[c] {
+				if !visit(n) {
+					return
+				}
+			}
+		}
+		return
+	}
+	for x := from.x; x <= to.x; x++ {
+		for y := from.y; y <= to.y; y++ {
+			for _, n := range g.cells[gridCell{x, y}] {
+				if !visit(n) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (g *GridIndex) RangeQuery(r Rect, points []BSPTreePoint) []BSPTreePoint {
+	return walkRangeQuery(g.walk, r, points)
+}
+
+func (g *GridIndex) RadiusQuery(p Point, radius float64, points []BSPTreePoint) []BSPTreePoint {
+	return walkRadiusQuery(g.walk, p, radius, points)
+}
+
+func (g *GridIndex) WeightWithin(p Point, radius float64, limit float64) float64 {
+	return walkWeightWithin(g.walk, p, radius, limit)
+}
+
+// Reads rings of cells around the cell of p, until the next ring is further away than the k-th location
+// Reads every cell instead once a ring has more cells than the grid.
+func (g *GridIndex) KNN(p Point, k int) []BSPTreePoint {
+	if k <= 0 || g.size == 0 {
+		return nil
+	}
+	res := newKNNResult(p, k)
+	c := g.cellOf(p.x, p.y)
+	for ring := int64(0); ; ring++ {
+		// Every location outside of the rings read so far is at least this far from p
+		if float64(ring-1)*g.cell >= res.worst() {
+			return res.points
+		}
+		if c.x-ring < g.from.x && c.y-ring < g.from.y && c.x+ring > g.to.x && c.y+ring > g.to.y {
+			return res.points // The rings cover every cell
+		}
+		if 8*ring > int64(len(g.cells)) {
+			res = newKNNResult(p, k)
+			for _, c := range g.order {
+				for _, n := range g.cells[c] {
+					res.add(n)
+				}
+			}
+			return res.points
+		}
+		for x := c.x - ring; x <= c.x+ring; x++ {
+			for y := c.y - ring; y <= c.y+ring; y++ {
+				if x != c.x-ring && x != c.x+ring && y != c.y-ring && y != c.y+ring {
+					y = c.y + ring - 1 // Inside of the ring, read by the previous rings
+					continue
+				}
+				for _, n := range g.cells[gridCell{x, y}] {
+					res.add(n)
+				}
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
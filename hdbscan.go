@@ -0,0 +1,424 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// HDBSCANResult holds the cluster of every location in the tree
+type HDBSCANResult struct {
+	points        []BSPTreePoint
+	labels        []int     // Cluster of every location, starting at 1, 0 means noise
+	probabilities []float64 // How strongly each location belongs to its cluster (0 to 1)
+}
+
+// A cluster of the condensed tree
+type condensedCluster struct {
+	parent    int
+	children  []int
+	birth     float64 // Lambda (1 / distance) at which the cluster appears
+	stability float64
+	selected  bool
+}
+
+// HDBSCAN: hierarchical DBSCAN that finds clusters of varying density without an epsilon.
+//   - The core distance of a location is the distance to its minPts-th nearest point (using the BSP tree)
+//   - The minimum spanning tree of the mutual reachability distance max(core(a), core(b), d(a, b))
+//     gives every DBSCAN clustering at once (single linkage)
+//   - The hierarchy is condensed by dropping splits that leave less than minClusterSize points
+//   - The most stable clusters of the condensed tree are kept
+//
+// Points in the same location are clustered together, every point counts as its weight. The spanning tree is built
+// with Borůvka's algorithm, searching the BSP tree for the edges with nWorkers threads.
+func hdbscan(bsp *BSPTree, minPts int, minClusterSize int, nWorkers int) HDBSCANResult {
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+	points := bsp.Query(bsp.rect)
+	res := HDBSCANResult{
+		points:        points,
+		labels:        make([]int, len(points)),
+		probabilities: make([]float64, len(points)),
+	}
+	if len(points) < 2 {
+		return res
+	}
+
+	// Core distances
+	core := make([]float64, len(points))
+	var wg sync.WaitGroup
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			for i := w; i < len(points); i += nWorkers {
+				nearest := bsp.Nearest(*points[i].Point, float64(minPts))
+				core[i] = nearest[len(nearest)-1].Point.Distance(*points[i].Point)
+			}
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+
+	edges := mutualReachabilityMST(bsp, points, core, nWorkers)
+	left, right, dist := singleLinkage(len(points), edges)
+	clusters, pointCluster, pointLambda := condenseTree(points, left, right, dist, minClusterSize)
+	selectClusters(clusters)
+
+	// Points belong to the selected cluster they (or the cluster they fell out of) descend from
+	ids := make(map[int]int)
+	maxLambda := make(map[int]float64)
+	for i := range points {
+		for c := pointCluster[i]; c > 0; c = clusters[c].parent {
+			if clusters[c].selected {
+				if _, ok := ids[c]; !ok {
+					ids[c] = len(ids) + 1
+				}
+				res.labels[i] = ids[c]
+				maxLambda[res.labels[i]] = math.Max(maxLambda[res.labels[i]], pointLambda[i])
+				break
+			}
+		}
+	}
+	for i, label := range res.labels {
+		if label != 0 && maxLambda[label] > 0 {
+			res.probabilities[i] = math.Min(pointLambda[i]/maxLambda[label], 1)
+		}
+	}
+	return res
+}
+
+// An edge of the minimum spanning tree
+type mstEdge struct {
+	a, b int
+	dist float64
+}
+
+// Is the edge lighter than the other one? Ties are broken on the ends, so every edge has its own place
+// in the order and Borůvka can't pick a cycle of equal edges.
+func (e mstEdge) less(other mstEdge) bool {
+	if e.dist != other.dist {
+		return e.dist < other.dist
+	}
+	a, b := minInt(e.a, e.b), maxInt(e.a, e.b)
+	otherA, otherB := minInt(other.a, other.b), maxInt(other.a, other.b)
+	return a < otherA || a == otherA && b < otherB
+}
+
+// The nodes of the BSP tree in pre-order, with what Borůvka needs to prune them
+// The left child of node k is k + 1, the right child is k + 1 + nodes[k + 1].
+type mstNodes struct {
+	nodes   []int     // Nodes in the subtree
+	bounds  []Rect    // Bounds of the points of the subtree
+	size    []int     // Locations in the subtree
+	point   []int     // Location of a leaf (index in points), -1 otherwise
+	minCore []float64 // Smallest core distance of the subtree
+	comp    []int     // Component of every location of the subtree, -1 if they are not all in the same one
+}
+
+func newMSTNodes(bsp *BSPTree, points []BSPTreePoint, core []float64) *mstNodes {
+	index := make(map[int]int, len(points)) // Index of every location id in points
+	for i, p := range points {
+		index[p.id] = i
+	}
+	m := &mstNodes{}
+	var add func(q *BSPTree) int
+	add = func(q *BSPTree) int {
+		k := len(m.nodes)
+		m.nodes = append(m.nodes, 1)
+		m.bounds = append(m.bounds, Rect{})
+		m.size = append(m.size, 0)
+		m.point = append(m.point, -1)
+		m.minCore = append(m.minCore, math.Inf(1))
+		if q.left != nil && q.right != nil {
+			left, right := k+1, k+1+add(q.left)
+			m.nodes[k] += m.nodes[left] + add(q.right)
+			for _, c := range []int{left, right} {
+				if m.size[c] == 0 {
+					continue
+				}
+				if m.size[k] == 0 {
+					m.bounds[k] = m.bounds[c]
+				} else {
+					m.bounds[k] = m.bounds[k].Merge(m.bounds[c])
+				}
+				m.size[k] += m.size[c]
+				m.minCore[k] = math.Min(m.minCore[k], m.minCore[c])
+			}
+		} else if q.point != nil && q.cnt > 0 {
+			i := index[q.id]
+			m.point[k] = i
+			m.bounds[k] = Rect{q.point.x, q.point.y, 0, 0}
+			m.size[k] = 1
+			m.minCore[k] = core[i]
+		}
+		return m.nodes[k]
+	}
+	add(bsp)
+	m.comp = make([]int, len(m.nodes))
+	return m
+}
+
+// Updates the components of the subtrees, children come after their parent in pre-order
+func (m *mstNodes) components(compOf []int) {
+	for k := len(m.nodes) - 1; k >= 0; k-- {
+		switch {
+		case m.size[k] == 0:
+			m.comp[k] = -1
+		case m.point[k] != -1:
+			m.comp[k] = compOf[m.point[k]]
+		default:
+			left := k + 1
+			right := left + m.nodes[left]
+			m.comp[k] = -1
+			if m.size[left] == 0 {
+				m.comp[k] = m.comp[right]
+			} else if m.size[right] == 0 || m.comp[left] == m.comp[right] {
+				m.comp[k] = m.comp[left]
+			}
+		}
+	}
+}
+
+// Lightest edge from location i to another component, best is the lightest edge found so far
+// Subtrees that are in the component of i, or that can't beat best, are skipped.
+func (m *mstNodes) nearest(k int, i int, points []BSPTreePoint, core []float64, compOf []int, best mstEdge) mstEdge {
+	p := *points[i].Point
+	if m.size[k] == 0 || m.comp[k] == compOf[i] {
+		return best
+	}
+	bound := math.Max(math.Max(core[i], m.minCore[k]), m.bounds[k].Distance(p))
+	if bound > best.dist {
+		return best
+	}
+	if j := m.point[k]; j != -1 {
+		edge := mstEdge{i, j, math.Max(math.Max(core[i], core[j]), p.Distance(*points[j].Point))}
+		if edge.less(best) {
+			return edge
+		}
+		return best
+	}
+
+	// Closest child first, so that best shrinks early
+	left := k + 1
+	right := left + m.nodes[left]
+	if m.size[right] > 0 && (m.size[left] == 0 || m.bounds[right].Distance(p) < m.bounds[left].Distance(p)) {
+		left, right = right, left
+	}
+	best = m.nearest(left, i, points, core, compOf, best)
+	return m.nearest(right, i, points, core, compOf, best)
+}
+
+// Borůvka's algorithm over the mutual reachability graph, with the BSP tree as the search structure
+// Every round finds the lightest edge out of every component and merges along them, so there are
+// at most log2(n) rounds. The edges are searched like nearest neighbors, the search skips the subtrees
+// that are all in the component of the location (most of the tree in the last rounds).
+func mutualReachabilityMST(bsp *BSPTree, points []BSPTreePoint, core []float64, nWorkers int) []mstEdge {
+	n := len(points)
+	m := newMSTNodes(bsp, points, core)
+	parent := make([]int, n) // Union-find over the locations
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	edges := make([]mstEdge, 0, n-1)
+	compOf := make([]int, n)
+	none := mstEdge{-1, -1, math.Inf(1)}
+	for len(edges) < n-1 {
+		for i := range compOf {
+			compOf[i] = find(i)
+		}
+		m.components(compOf)
+
+		// Every worker keeps the lightest edge of the components it has seen
+		found := make([]map[int]mstEdge, nWorkers)
+		var wg sync.WaitGroup
+		for w := 0; w < nWorkers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				lightest := make(map[int]mstEdge)
+				for i := w; i < n; i += nWorkers {
+					best, ok := lightest[compOf[i]]
+					if !ok {
+						best = none
+					}
+					if edge := m.nearest(0, i, points, core, compOf, best); edge.a != -1 {
+						lightest[compOf[i]] = edge
+					}
+				}
+				found[w] = lightest
+				wg.Done()
+			}(w)
+		}
+		wg.Wait()
+
+		lightest := make(map[int]mstEdge)
+		for _, edges := range found {
+			for c, edge := range edges {
+				if best, ok := lightest[c]; !ok || edge.less(best) {
+					lightest[c] = edge
+				}
+			}
+		}
+		if len(lightest) == 0 { // Only happens if the tree misses locations or a core distance is NaN
+			// The components can't be reached from each other, join them at an infinite distance so that
+			// the dendrogram still has a single root
+			first := -1
+			for i := range parent {
+				if c := find(i); c == i && first == -1 {
+					first = c
+				} else if c == i {
+					parent[c] = first
+					edges = append(edges, mstEdge{first, c, math.Inf(1)})
+				}
+			}
+			break
+		}
+		for _, edge := range lightest {
+			if a, b := find(edge.a), find(edge.b); a != b {
+				parent[a] = b
+				edges = append(edges, edge)
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { // Same order whatever the order of the maps
+		return edges[i].less(edges[j])
+	})
+	return edges
+}
+
+// Builds the single linkage dendrogram from the spanning tree
+// Nodes below n are the points, node n + j is the j-th merge of left[j] and right[j] at distance dist[j]
+func singleLinkage(n int, edges []mstEdge) (left []int, right []int, dist []float64) {
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].dist < edges[j].dist
+	})
+
+	parent := make([]int, 2*n-1) // Union-find over the dendrogram nodes
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	for j, e := range edges {
+		a, b := find(e.a), find(e.b)
+		left = append(left, a)
+		right = append(right, b)
+		dist = append(dist, e.dist)
+		parent[a] = n + j
+		parent[b] = n + j
+	}
+	return left, right, dist
+}
+
+// Walks the dendrogram from the top, only keeping the splits where both sides have at least minClusterSize points.
+// Returns the clusters (0 is the root) and, for every point, the cluster it fell out of and at which lambda.
+func condenseTree(points []BSPTreePoint, left []int, right []int, dist []float64, minClusterSize int) (
+	clusters []condensedCluster, pointCluster []int, pointLambda []float64) {
+	n := len(points)
+	size := make([]float64, 2*n-1)
+	for i, p := range points {
+		size[i] = p.weight
+	}
+	for j := range left {
+		size[n+j] = size[left[j]] + size[right[j]]
+	}
+
+	clusters = []condensedCluster{{parent: -1}}
+	pointCluster = make([]int, n)
+	pointLambda = make([]float64, n)
+
+	// Every point under node falls out of cluster c at lambda
+	fallOut := func(node int, c int, lambda float64) {
+		stack := []int{node}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if node < n {
+				pointCluster[node] = c
+				pointLambda[node] = lambda
+				clusters[c].stability += (lambda - clusters[c].birth) * size[node]
+				continue
+			}
+			stack = append(stack, left[node-n], right[node-n])
+		}
+	}
+
+	type task struct{ node, cluster int }
+	stack := []task{{2*n - 2, 0}}
+	for len(stack) > 0 {
+		t := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		j := t.node - n
+		lambda := 1 / dist[j]
+		if dist[j] == 0 {
+			lambda = math.MaxFloat64
+		}
+
+		// A single location can't be split anymore, so it never becomes a cluster on its own
+		l, r := left[j], right[j]
+		bigL := size[l] >= float64(minClusterSize) && l >= n
+		bigR := size[r] >= float64(minClusterSize) && r >= n
+
+		switch {
+		case bigL && bigR: // A real split, both sides become new clusters
+			for _, child := range []int{l, r} {
+				id := len(clusters)
+				clusters = append(clusters, condensedCluster{parent: t.cluster, birth: lambda})
+				clusters[t.cluster].children = append(clusters[t.cluster].children, id)
+				clusters[t.cluster].stability += (lambda - clusters[t.cluster].birth) * size[child]
+				stack = append(stack, task{child, id})
+			}
+		case bigL: // The cluster goes on, with fewer points
+			fallOut(r, t.cluster, lambda)
+			stack = append(stack, task{l, t.cluster})
+		case bigR:
+			fallOut(l, t.cluster, lambda)
+			stack = append(stack, task{r, t.cluster})
+		default: // The cluster is gone
+			fallOut(l, t.cluster, lambda)
+			fallOut(r, t.cluster, lambda)
+		}
+	}
+	return clusters, pointCluster, pointLambda
+}
+
+// Excess of mass: keep a cluster unless its children are more stable together.
+// The root is never selected, so a single cluster is reported as noise (like the reference implementation).
+func selectClusters(clusters []condensedCluster) {
+	// Children always have a bigger id than their parent
+	stability := make([]float64, len(clusters))
+	for c := len(clusters) - 1; c > 0; c-- {
+		children := 0.0
+		for _, child := range clusters[c].children {
+			children += stability[child]
+		}
+		if children > clusters[c].stability {
+			stability[c] = children
+			continue
+		}
+
+		stability[c] = clusters[c].stability
+		clusters[c].selected = true
+		stack := append([]int{}, clusters[c].children...)
+		for len(stack) > 0 {
+			child := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			clusters[child].selected = false
+			stack = append(stack, clusters[child].children...)
+		}
+	}
+}
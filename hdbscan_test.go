@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestHDBSCANVaryingDensity(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := []Point{}
+	// A dense blob, a blob 20 times sparser and some noise around them
+	for i := 0; i < 200; i++ {
+		points = append(points, Point{rng.NormFloat64() * 0.05, rng.NormFloat64() * 0.05})
+	}
+	for i := 0; i < 200; i++ {
+		points = append(points, Point{10 + rng.NormFloat64(), 10 + rng.NormFloat64()})
+	}
+	for i := 0; i < 20; i++ {
+		points = append(points, Point{-20 + rng.Float64()*60, -20 + rng.Float64()*60})
+	}
+
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	res := hdbscan(bsp, 5, 15, 4)
+
+	clusters, _ := labelSummary(res.labels)
+	if clusters != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", clusters)
+	}
+
+	// Most of each blob is in its own cluster
+	votes := map[int]map[int]int{0: {}, 1: {}}
+	for i, p := range res.points {
+		if res.probabilities[i] < 0 || res.probabilities[i] > 1 {
+			t.Errorf("Probability out of range: %f", res.probabilities[i])
+		}
+		if res.labels[i] == 0 && res.probabilities[i] != 0 {
+			t.Error("Noise has a probability")
+		}
+		if p.x < 5 && p.y < 5 && p.Distance(Point{0, 0}) < 0.3 {
+			votes[0][res.labels[i]]++
+		} else if p.Distance(Point{10, 10}) < 3 {
+			votes[1][res.labels[i]]++
+		}
+	}
+	majority := map[int]int{}
+	for blob, v := range votes {
+		best, total := 0, 0
+		for label, n := range v {
+			total += n
+			if label != 0 && n > best {
+				best, majority[blob] = n, label
+			}
+		}
+		if best < total*9/10 {
+			t.Errorf("Blob %d is not clustered together: %v", blob, v)
+		}
+	}
+	if majority[0] == majority[1] {
+		t.Error("Both blobs are in the same cluster")
+	}
+}
+
+func TestHDBSCANTiny(t *testing.T) {
+	points := []Point{{1, 1}, {1, 1}}
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	res := hdbscan(bsp, 2, 2, 2)
+	if len(res.points) != 1 || res.labels[0] != 0 {
+		t.Errorf("Single location should be noise: %v", res.labels)
+	}
+}
+
+func TestHDBSCANWorkers(t *testing.T) {
+	points, _ := randomDataset(rand.New(rand.NewSource(3)), 800)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	expected := hdbscan(bsp, 5, 10, 1)
+	for _, nWorkers := range []int{0, -2, 3} {
+		res := hdbscan(bsp, 5, 10, nWorkers)
+		for i := range res.labels {
+			if res.labels[i] != expected.labels[i] || res.probabilities[i] != expected.probabilities[i] {
+				t.Fatalf("%d workers: location %d has label %d (%f) instead of %d (%f)", nWorkers, i,
+					res.labels[i], res.probabilities[i], expected.labels[i], expected.probabilities[i])
+			}
+		}
+	}
+}
+
+func TestMutualReachabilityMST(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	// Points snapped to a grid, so that many edges have the same length
+	points := make([]Point, 600)
+	for i := range points {
+		points[i] = Point{float64(rng.Intn(50)) / 2, float64(rng.Intn(30)) / 2}
+	}
+	bsp := NewBSPTreeFromPoints(Rect{0, 0, 5, 5}, &points) // Grows to fit the points
+	locations := bsp.Query(bsp.rect)
+	core := make([]float64, len(locations))
+	for i, p := range locations {
+		nearest := bsp.Nearest(*p.Point, 4)
+		core[i] = nearest[len(nearest)-1].Point.Distance(*p.Point)
+	}
+	mutual := func(i, j int) float64 {
+		return math.Max(math.Max(core[i], core[j]), locations[i].Point.Distance(*locations[j].Point))
+	}
+
+	// O(n^2) Prim as the reference
+	expected := 0.0
+	inTree := make([]bool, len(locations))
+	best := make([]float64, len(locations))
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+	for current, k := 0, 1; k < len(locations); k++ {
+		inTree[current] = true
+		next := -1
+		for i := range locations {
+			if inTree[i] {
+				continue
+			}
+			best[i] = math.Min(best[i], mutual(current, i))
+			if next == -1 || best[i] < best[next] {
+				next = i
+			}
+		}
+		expected += best[next]
+		current = next
+	}
+
+	for _, nWorkers := range []int{1, 3} {
+		edges := mutualReachabilityMST(bsp, locations, core, nWorkers)
+		if len(edges) != len(locations)-1 {
+			t.Fatalf("Spanning tree has %d edges for %d locations", len(edges), len(locations))
+		}
+		total := 0.0
+		for _, e := range edges {
+			if e.dist != mutual(e.a, e.b) {
+				t.Errorf("Edge %v has the wrong distance", e)
+			}
+			total += e.dist
+		}
+		if math.Abs(total-expected) > 1e-9 {
+			t.Errorf("Spanning tree weighs %f instead of %f", total, expected)
+		}
+	}
+}
+
+func TestMutualReachabilityMSTDisconnected(t *testing.T) {
+	// A location with a NaN core distance has no edge of any length
+	points := []Point{{0, 0}, {1, 0}, {5, 5}, {6, 5}}
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	locations := bsp.Query(bsp.rect)
+	core := []float64{1, 1, math.NaN(), 1}
+
+	edges := mutualReachabilityMST(bsp, locations, core, 2)
+	if len(edges) != len(locations)-1 {
+		t.Fatalf("Spanning tree has %d edges for %d locations", len(edges), len(locations))
+	}
+	if last := edges[len(edges)-1]; !math.IsInf(last.dist, 1) {
+		t.Errorf("Expected the components to be joined at an infinite distance, got %v", edges)
+	}
+	left, right, dist := singleLinkage(len(locations), edges)
+	condenseTree(locations, left, right, dist, 2) // Must not index past the dendrogram
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"sort"
+)
+
+// Kind of change made to a cluster by an incremental update
+type ClusterEventKind int
+
+const (
+	ClusterCreated ClusterEventKind = iota // A new cluster appeared
+	ClusterGrown                           // Points joined the cluster
+	ClusterShrunk                          // Points left the cluster
+	ClusterMerged                          // The clusters in Others were absorbed by the cluster
+	ClusterSplit                           // The cluster broke apart, the new pieces are in Others
+	ClusterRemoved                         // The cluster has no core points left
+)
+
+func (k ClusterEventKind) String() string {
+	return [...]string{"created", "grown", "shrunk", "merged", "split", "removed"}[k]
+}
+
+// ClusterEvent describes a change to a single cluster
+type ClusterEvent struct {
+	Kind      ClusterEventKind
+	ClusterId int
+	Others    []int // Clusters merged into ClusterId or split off from it
+	Size      int   // Number of points in the cluster after the change
+}
+
+// IncrementalDBSCAN keeps a DBSCAN labeling up to date while points are inserted and removed.
+// Every update only looks at the neighborhood of the point and at the clusters it touches,
+// instead of re-clustering everything.
+// A point is a core point if there are at least minPts points within epsilon of it (itself included).
+type IncrementalDBSCAN struct {
+	bsp      *BSPTree
+	epsilon  float64
+	minPts   int
+	counts   map[Point]int          // How many points are at each location
+	labels   map[Point]int          // Cluster of each clustered location (noise is left out)
+	core     map[Point]bool         // Locations that are core points
+	clusters map[int]map[Point]bool // Locations in each cluster
+	nextId   int
+}
+
+// Create a new incremental clusterer, r is only a hint since the tree grows as needed
+func NewIncrementalDBSCAN(r Rect, epsilon float64, minPts int) *IncrementalDBSCAN {
+	return &IncrementalDBSCAN{
+		bsp:      NewBSPTree(r.x, r.y, r.w, r.h),
+		epsilon:  epsilon,
+		minPts:   minPts,
+		counts:   make(map[Point]int),
+		labels:   make(map[Point]int),
+		core:     make(map[Point]bool),
+		clusters: make(map[int]map[Point]bool),
+		nextId:   1,
+	}
+}
+
+// Returns the cluster of a point, 0 means noise
+func (c *IncrementalDBSCAN) Label(p Point) int {
+	return c.labels[p]
+}
+
+// Returns the locations in every cluster
+func (c *IncrementalDBSCAN) Clusters() map[int][]Point {
+	res := make(map[int][]Point, len(c.clusters))
+	for id, members := range c.clusters {
+		for p := range members {
+			res[id] = append(res[id], p)
+		}
+	}
+	return res
+}
+
+// Number of points in a cluster
+func (c *IncrementalDBSCAN) Size(id int) int {
+	size := 0
+	for p := range c.clusters[id] {
+		size += c.counts[p]
+	}
+	return size
+}
+
+// Adds a point and returns how the clusters changed
+func (c *IncrementalDBSCAN) Insert(p Point) []ClusterEvent {
+	pp := p
+	c.bsp.Insert(&pp)
+	c.counts[p]++
+
+	// Only the neighbors of p can become core points, every new core point is expanded
+	// until we reach the old core points, which already know their cluster.
+	// Clusters only merge through core points: p joins the clusters next to it only if it is one.
+	ids := make(map[int]bool)
+	expanded := make(map[Point]bool)
+	members := []Point{}
+	queue := []Point{}
+	for _, n := range c.neighbors(p) {
+		if m := *n.Point; !c.core[m] && c.isCore(m) {
+			c.core[m] = true
+			expanded[m] = true
+			queue = append(queue, m)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		members = append(members, current)
+		for _, n := range c.neighbors(current) {
+			m := *n.Point
+			members = append(members, m)
+			if expanded[m] {
+				continue
+			}
+			if c.core[m] {
+				ids[c.labels[m]] = true
+			} else if c.isCore(m) {
+				c.core[m] = true
+				expanded[m] = true
+				queue = append(queue, m)
+			}
+		}
+	}
+
+	if len(expanded) == 0 { // p is a border point of its closest core point, or noise
+		return c.attach(p)
+	}
+
+	var event ClusterEvent
+	var id int
+	if len(ids) == 0 {
+		id = c.newCluster()
+		event = ClusterEvent{Kind: ClusterCreated, ClusterId: id}
+	} else {
+		others := sortedKeys(ids)
+		id, others = others[0], others[1:]
+		event = ClusterEvent{Kind: ClusterGrown, ClusterId: id}
+		if len(others) > 0 {
+			event = ClusterEvent{Kind: ClusterMerged, ClusterId: id, Others: others}
+		}
+		for _, other := range others {
+			for m := range c.clusters[other] {
+				c.labels[m] = id
+				c.clusters[id][m] = true
+			}
+			delete(c.clusters, other)
+		}
+	}
+
+	for _, m := range members {
+		if c.core[m] && c.labels[m] != id {
+			c.labels[m] = id
+			c.clusters[id][m] = true
+		}
+	}
+
+	// The border points join the cluster of their closest core point, they move when a new core point
+	// is closer to them than the one of their cluster
+	grown, shrunk := make(map[int]bool), make(map[int]bool)
+	seen := make(map[Point]bool)
+	for _, m := range members {
+		if c.core[m] || seen[m] {
+			continue
+		}
+		seen[m] = true
+		closest := c.closestCore(m)
+		if closest == nil {
+			continue
+		}
+		l, ok := c.labels[m]
+		if target := c.labels[*closest]; !ok || l != target {
+			if ok {
+				delete(c.clusters[l], m)
+				shrunk[l] = true
+			}
+			c.labels[m] = target
+			c.clusters[target][m] = true
+			grown[target] = true
+		}
+	}
+
+	event.Size = c.Size(id)
+	events := []ClusterEvent{event}
+	for _, other := range sortedKeys(grown) {
+		if other != id {
+			events = append(events, ClusterEvent{Kind: ClusterGrown, ClusterId: other, Size: c.Size(other)})
+		}
+	}
+	for _, other := range sortedKeys(shrunk) {
+		events = append(events, ClusterEvent{Kind: ClusterShrunk, ClusterId: other, Size: c.Size(other)})
+	}
+	return events
+}
+
+// Closest core point within epsilon of p, nil if there is none
+func (c *IncrementalDBSCAN) closestCore(p Point) *Point {
+	var closest *Point
+	for _, n := range c.neighbors(p) {
+		if m := *n.Point; c.core[m] && (closest == nil || closerTo(p, m, *closest)) {
+			closest = &m
+		}
+	}
+	return closest
+}
+
+// Adds a point that is not a core point to the cluster of its closest core point
+// A point at a location that is already in a cluster stays there: no core point appeared, so its closest one is the same.
+func (c *IncrementalDBSCAN) attach(p Point) []ClusterEvent {
+	if id, ok := c.labels[p]; ok {
+		return []ClusterEvent{{Kind: ClusterGrown, ClusterId: id, Size: c.Size(id)}}
+	}
+	closest := c.closestCore(p)
+	if closest == nil {
+		return nil
+	}
+	id := c.labels[*closest]
+	c.labels[p] = id
+	c.clusters[id][p] = true
+	return []ClusterEvent{{Kind: ClusterGrown, ClusterId: id, Size: c.Size(id)}}
+}
+
+// Removes one occurrence of a point and returns how the clusters changed
+// Returns false (and leaves the clusters untouched) if the point is not in the clustering
+func (c *IncrementalDBSCAN) Remove(p Point) ([]ClusterEvent, bool) {
+	pp := p
+	if c.counts[p] == 0 || !c.bsp.Remove(&pp) {
+		return nil, false
+	}
+
+	label, labeled := c.labels[p]
+	affected := make(map[int]bool)
+	c.counts[p]--
+	if c.counts[p] == 0 {
+		delete(c.counts, p)
+		if c.core[p] {
+			affected[label] = true
+		}
+		delete(c.core, p)
+		if labeled {
+			delete(c.labels, p)
+			delete(c.clusters[label], p)
+		}
+	}
+
+	// Only the neighbors of p can stop being core points
+	for _, n := range c.neighbors(p) {
+		if m := *n.Point; c.core[m] && !c.isCore(m) {
+			delete(c.core, m)
+			affected[c.labels[m]] = true
+		}
+	}
+
+	if len(affected) == 0 {
+		if !labeled {
+			return nil, true
+		}
+		return []ClusterEvent{{Kind: ClusterShrunk, ClusterId: label, Size: c.Size(label)}}, true
+	}
+
+	var events []ClusterEvent
+	if labeled && !affected[label] {
+		events = append(events, ClusterEvent{Kind: ClusterShrunk, ClusterId: label, Size: c.Size(label)})
+	}
+	for _, id := range sortedKeys(affected) {
+		events = append(events, c.recluster(id)...)
+	}
+	return events, true
+}
+
+// Rebuilds a cluster after some of its core points were lost
+// The biggest remaining piece keeps the id, the other pieces get new ones
+func (c *IncrementalDBSCAN) recluster(id int) []ClusterEvent {
+	members := c.clusters[id]
+	delete(c.clusters, id)
+	for m := range members {
+		delete(c.labels, m)
+	}
+
+	// Find the pieces that are still density connected
+	seen := make(map[Point]bool)
+	pieces := [][]Point{}
+	for _, start := range sortedPoints(members) {
+		if !c.core[start] || seen[start] {
+			continue
+		}
+		seen[start] = true
+		piece := []Point{}
+		queue := []Point{start}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			piece = append(piece, current)
+			for _, n := range c.neighbors(current) {
+				m := *n.Point
+				if seen[m] {
+					continue
+				}
+				if c.core[m] {
+					seen[m] = true
+					queue = append(queue, m)
+				} else if _, ok := c.labels[m]; !ok { // Border point that is free to take
+					seen[m] = true
+					piece = append(piece, m)
+				}
+			}
+		}
+		pieces = append(pieces, piece)
+	}
+
+	// Biggest piece first
+	weight := func(piece []Point) int {
+		w := 0
+		for _, m := range piece {
+			w += c.counts[m]
+		}
+		return w
+	}
+	sort.SliceStable(pieces, func(i, j int) bool {
+		return weight(pieces[i]) > weight(pieces[j])
+	})
+
+	others := []int{}
+	reported := map[int]bool{id: true} // The pieces, their sizes are in the split event
+	for i, piece := range pieces {
+		pieceId := id
+		if i > 0 {
+			pieceId = c.newCluster()
+			others = append(others, pieceId)
+			reported[pieceId] = true
+		} else {
+			c.clusters[id] = make(map[Point]bool)
+		}
+		for _, m := range piece {
+			if c.core[m] {
+				c.labels[m] = pieceId
+				c.clusters[pieceId][m] = true
+			}
+		}
+	}
+
+	// The border points join the cluster of their closest core point, which may be a neighboring cluster
+	grown := make(map[int]bool)
+	for _, m := range sortedPoints(members) {
+		if _, ok := c.labels[m]; ok {
+			continue
+		}
+		closest := c.closestCore(m)
+		if closest == nil {
+			continue
+		}
+		other := c.labels[*closest]
+		c.labels[m] = other
+		c.clusters[other][m] = true
+		grown[other] = true
+	}
+
+	var events []ClusterEvent
+	if len(pieces) == 0 {
+		events = append(events, ClusterEvent{Kind: ClusterRemoved, ClusterId: id})
+	} else if len(pieces) == 1 {
+		events = append(events, ClusterEvent{Kind: ClusterShrunk, ClusterId: id, Size: c.Size(id)})
+	} else {
+		events = append(events, ClusterEvent{Kind: ClusterSplit, ClusterId: id, Others: others, Size: c.Size(id)})
+	}
+	for _, other := range sortedKeys(grown) {
+		if reported[other] {
+			continue
+		}
+		events = append(events, ClusterEvent{Kind: ClusterGrown, ClusterId: other, Size: c.Size(other)})
+	}
+
+	return events
+}
+
+// Returns the locations within epsilon of the point (including the point itself)
+func (c *IncrementalDBSCAN) neighbors(p Point) []BSPTreePoint {
+	r := Rect{p.x - c.epsilon, p.y - c.epsilon, c.epsilon * 2, c.epsilon * 2}
+	neighbors := []BSPTreePoint{}
+	for _, n := range c.bsp.Query(r) {
+		if n.Point.Distance(p) <= c.epsilon {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors
+}
+
+func (c *IncrementalDBSCAN) isCore(p Point) bool {
+	if _, ok := c.counts[p]; !ok {
+		return false
+	}
+	weight := 0.0
+	for _, neighbor := range c.neighbors(p) {
+		weight += neighbor.weight
+	}
+	return weight >= float64(c.minPts)
+}
+
+func (c *IncrementalDBSCAN) newCluster() int {
+	id := c.nextId
+	c.nextId++
+	c.clusters[id] = make(map[Point]bool)
+	return id
+}
+
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Sorting keeps the results independent of map iteration order
+func sortedPoints(m map[Point]bool) []Point {
+	points := make([]Point, 0, len(m))
+	for p := range m {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].x != points[j].x {
+			return points[i].x < points[j].x
+		}
+		return points[i].y < points[j].y
+	})
+	return points
+}
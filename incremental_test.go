@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Checks the labeling against the DBSCAN definition
+func checkIncremental(t *testing.T, c *IncrementalDBSCAN) {
+	for p := range c.counts {
+		if c.isCore(p) != c.core[p] {
+			t.Fatalf("Core status of %v is stale", p)
+		}
+		label := c.labels[p]
+		hasCore, attached := false, false
+		for _, n := range c.neighbors(p) {
+			if !c.core[*n.Point] {
+				continue
+			}
+			hasCore = true
+			attached = attached || c.labels[*n.Point] == label
+			if c.core[p] && c.labels[*n.Point] != label {
+				t.Fatalf("Core points %v and %v are in different clusters", p, *n.Point)
+			}
+		}
+		if c.core[p] && label == 0 {
+			t.Fatalf("Core point %v is noise", p)
+		}
+		if !c.core[p] && hasCore != attached {
+			t.Fatalf("Point %v has label %d but core neighbors: %v", p, label, hasCore)
+		}
+		if label != 0 && !c.clusters[label][p] {
+			t.Fatalf("Cluster %d does not list %v", label, p)
+		}
+	}
+	for id, members := range c.clusters {
+		core := false
+		for p := range members {
+			if c.labels[p] != id {
+				t.Fatalf("Cluster %d lists %v labeled %d", id, p, c.labels[p])
+			}
+			core = core || c.core[p]
+		}
+		if !core {
+			t.Fatalf("Cluster %d has no core point", id)
+		}
+	}
+}
+
+// Checks the labels against a DBSCAN run from scratch: same clusters, whatever the ids
+func checkIncrementalReference(t *testing.T, c *IncrementalDBSCAN) {
+	t.Helper()
+	locations := []Point{}
+	weights := []float64{}
+	for p, n := range c.counts {
+		locations = append(locations, p)
+		weights = append(weights, float64(n))
+	}
+	neighbors := func(i int) []int {
+		neighbors := []int{}
+		for j, q := range locations {
+			if locations[i].Distance(q) <= c.epsilon {
+				neighbors = append(neighbors, j)
+			}
+		}
+		return neighbors
+	}
+	expected := dbscanLabels(len(locations), weights, neighbors, c.minPts)
+	isCore := make([]bool, len(locations))
+	for i := range locations {
+		w := 0.0
+		for _, j := range neighbors(i) {
+			w += weights[j]
+		}
+		isCore[i] = w >= float64(c.minPts)
+	}
+
+	mapping, reverse := make(map[int]int), make(map[int]int)
+	for i, p := range locations {
+		if !c.core[p] {
+			continue
+		}
+		label := c.labels[p]
+		if m, ok := mapping[label]; ok && m != expected[i] {
+			t.Fatalf("Cluster %d holds core points of two clusters", label)
+		}
+		if r, ok := reverse[expected[i]]; ok && r != label {
+			t.Fatalf("Cluster of %v is split between %d and %d", p, r, label)
+		}
+		mapping[label], reverse[expected[i]] = expected[i], label
+	}
+
+	// Border points are in the cluster of their closest core point
+	for i, p := range locations {
+		if isCore[i] {
+			continue
+		}
+		closest := -1
+		for _, j := range neighbors(i) {
+			if isCore[j] && (closest == -1 || closerTo(p, locations[j], locations[closest])) {
+				closest = j
+			}
+		}
+		want := 0
+		if closest != -1 {
+			want = reverse[expected[closest]]
+		}
+		if c.labels[p] != want {
+			t.Fatalf("Border point %v is in cluster %d instead of %d", p, c.labels[p], want)
+		}
+	}
+}
+
+func TestIncrementalBorderBridge(t *testing.T) {
+	// Two clusters, the new point is within epsilon of both but is not a core point itself
+	c := NewIncrementalDBSCAN(Rect{0, 0, 3, 1}, 1, 4)
+	for _, p := range []Point{{0, 0}, {-0.5, 0}, {-0.5, 0.1}, {-0.5, -0.1}, {1.8, 0}, {2.3, 0}, {2.3, 0.1}, {2.3, -0.1}} {
+		c.Insert(p)
+	}
+	left, right := c.Label(Point{0, 0}), c.Label(Point{1.8, 0})
+	if left == 0 || right == 0 || left == right {
+		t.Fatalf("Expected two clusters, got %d and %d", left, right)
+	}
+
+	bridge := Point{0.9, 0} // As close to both, the tie goes to the smallest x (see closerTo)
+	events := c.Insert(bridge)
+	if c.isCore(bridge) {
+		t.Fatal("The bridge is a core point")
+	}
+	if len(events) != 1 || events[0].Kind != ClusterGrown || events[0].ClusterId != left {
+		t.Errorf("Expected the closest cluster %d to grow, got %v", left, events)
+	}
+	if c.Label(Point{0, 0}) == c.Label(Point{1.8, 0}) || c.Label(bridge) != left {
+		t.Error("A border point merged the clusters")
+	}
+	checkIncremental(t, c)
+	checkIncrementalReference(t, c)
+}
+
+func TestIncrementalEvents(t *testing.T) {
+	c := NewIncrementalDBSCAN(Rect{0, 0, 10, 10}, 1, 3)
+
+	// Two separate groups
+	for _, p := range []Point{{0, 0}, {0.5, 0}, {0, 0.5}} {
+		c.Insert(p)
+	}
+	events := c.Insert(Point{0.5, 0.5})
+	if len(events) != 1 || events[0].Kind != ClusterGrown || events[0].Size != 4 {
+		t.Errorf("Expected grown event, got %v", events)
+	}
+	left := c.Label(Point{0, 0})
+
+	c.Insert(Point{4, 0})
+	c.Insert(Point{4.5, 0})
+	events = c.Insert(Point{4, 0.5})
+	if len(events) != 1 || events[0].Kind != ClusterCreated || events[0].Size != 3 {
+		t.Errorf("Expected created event, got %v", events)
+	}
+	right := c.Label(Point{4, 0})
+	if left == 0 || right == 0 || left == right {
+		t.Fatal("Groups were not clustered separately")
+	}
+	if c.Insert(Point{9, 9}) != nil || c.Label(Point{9, 9}) != 0 {
+		t.Error("Isolated point is not noise")
+	}
+	checkIncremental(t, c)
+
+	// Bridge the groups
+	c.Insert(Point{1.5, 0})
+	c.Insert(Point{2.25, 0})
+	events = c.Insert(Point{3, 0})
+	if len(events) != 1 || events[0].Kind != ClusterMerged || events[0].ClusterId != left || events[0].Others[0] != right {
+		t.Errorf("Expected merge event, got %v", events)
+	}
+	if c.Label(Point{4, 0}) != left || c.Size(left) != 10 {
+		t.Error("Groups were not merged")
+	}
+	checkIncremental(t, c)
+
+	// Break the bridge
+	events, _ = c.Remove(Point{2.25, 0})
+	if len(events) != 1 || events[0].Kind != ClusterSplit || events[0].ClusterId != left || len(events[0].Others) != 1 {
+		t.Errorf("Expected split event, got %v", events)
+	}
+	if c.Label(Point{0, 0}) == c.Label(Point{4, 0}) {
+		t.Error("Groups were not split")
+	}
+	checkIncremental(t, c)
+
+	// Remove a group
+	right = c.Label(Point{4, 0})
+	c.Remove(Point{4.5, 0})
+	c.Remove(Point{4, 0.5})
+	if c.Label(Point{4, 0}) != 0 {
+		t.Error("Lonely point is still clustered")
+	}
+	if _, ok := c.clusters[right]; ok {
+		t.Error("Cluster was not removed")
+	}
+	checkIncremental(t, c)
+
+	if events, ok := c.Remove(Point{100, 100}); ok || events != nil {
+		t.Error("Removing a missing point changed the clusters")
+	}
+}
+
+func TestIncrementalRemoveBorder(t *testing.T) {
+	// Two clusters, the border point between them is closest to a core point of the left one until it is removed
+	c := NewIncrementalDBSCAN(Rect{-1, -1, 4, 2}, 1, 5)
+	for i := 0; i < 4; i++ {
+		c.Insert(Point{-0.5, 0})
+		c.Insert(Point{2.1, 0})
+	}
+	for _, p := range []Point{{0, 0}, {0.5, 0}, {1.6, 0}} {
+		c.Insert(p)
+	}
+	border := Point{1, 0}
+	c.Insert(border)
+	left, right := c.Label(Point{0, 0}), c.Label(Point{1.6, 0})
+	if left == 0 || right == 0 || left == right || c.Label(border) != left {
+		t.Fatalf("Expected the border point in the left cluster, got %d (left %d, right %d)", c.Label(border), left, right)
+	}
+
+	events, _ := c.Remove(Point{0.5, 0})
+	if c.isCore(border) || !c.core[Point{0, 0}] {
+		t.Fatal("Unexpected core points")
+	}
+	if c.Label(border) != right {
+		t.Errorf("Expected the border point to join its closest core point in cluster %d, got %d", right, c.Label(border))
+	}
+	if len(events) != 2 || events[0].Kind != ClusterShrunk || events[0].ClusterId != left ||
+		events[1].Kind != ClusterGrown || events[1].ClusterId != right {
+		t.Errorf("Expected the left cluster to shrink and the right one to grow, got %v", events)
+	}
+	checkIncremental(t, c)
+	checkIncrementalReference(t, c)
+}
+
+func TestIncrementalRemoveGrown(t *testing.T) {
+	// The group sits on the far edge of the initial bounds, which the tree grows past
+	c := NewIncrementalDBSCAN(Rect{0, 0, 10, 10}, 1, 3)
+	group := []Point{{10, 5}, {10, 5.5}, {10, 4.5}, {9.5, 5}}
+	for _, p := range group {
+		c.Insert(p)
+	}
+	c.Insert(Point{25, 5})
+	c.Insert(Point{-30, 40})
+	id := c.Label(Point{10, 5})
+	if id == 0 {
+		t.Fatal("Group is not clustered")
+	}
+
+	for i, p := range group[:2] {
+		events, ok := c.Remove(p)
+		if !ok {
+			t.Fatalf("Could not remove %v", p)
+		}
+		if i == 1 && (len(events) != 1 || events[0].Kind != ClusterRemoved) {
+			t.Errorf("Expected removed event, got %v", events)
+		}
+		checkIncremental(t, c)
+	}
+	if _, ok := c.Remove(group[0]); ok {
+		t.Error("Removed a point twice")
+	}
+	if c.Label(Point{10, 4.5}) != 0 || len(c.Clusters()) != 0 {
+		t.Error("Cluster was not removed")
+	}
+}
+
+func TestIncrementalInsertOrder(t *testing.T) {
+	// Same points as TestIncrementalRemoveBorder: the border point is closest to (0.5, 0) of the left cluster
+	points := []Point{{-0.5, 0}, {-0.5, 0}, {-0.5, 0}, {-0.5, 0}, {2.1, 0}, {2.1, 0}, {2.1, 0}, {2.1, 0}, {0, 0}, {1.6, 0}}
+	border, closer := Point{1, 0}, Point{0.5, 0}
+	orders := map[string][]Point{
+		"closer core point first": append(append(append([]Point{}, points...), closer), border),
+		"border point first":      append(append(append([]Point{}, points...), border), closer),
+	}
+	for name, order := range orders {
+		c := NewIncrementalDBSCAN(Rect{-1, -1, 4, 2}, 1, 5)
+		var events []ClusterEvent
+		for _, p := range order {
+			events = c.Insert(p)
+		}
+		left, right := c.Label(Point{0, 0}), c.Label(Point{1.6, 0})
+		if c.isCore(border) || left == right || c.Label(border) != left {
+			t.Errorf("%s: expected the border point in the left cluster %d, got %d", name, left, c.Label(border))
+		}
+		if name == "border point first" && (len(events) != 2 || events[0].Kind != ClusterGrown || events[0].ClusterId != left ||
+			events[1].Kind != ClusterShrunk || events[1].ClusterId != right) {
+			t.Errorf("%s: expected the left cluster to grow and the right one to shrink, got %v", name, events)
+		}
+		checkIncremental(t, c)
+		checkIncrementalReference(t, c)
+	}
+}
+
+func TestIncrementalRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := NewIncrementalDBSCAN(Rect{0, 0, 10, 10}, 0.6, 4)
+	points := []Point{}
+	for i := 0; i < 2000; i++ {
+		if len(points) > 0 && rng.Float64() < 0.3 {
+			j := rng.Intn(len(points))
+			c.Remove(points[j])
+			points = append(points[:j], points[j+1:]...)
+		} else {
+			// Snap to a grid to get duplicates
+			p := Point{float64(rng.Intn(40)) / 4, float64(rng.Intn(40)) / 4}
+			c.Insert(p)
+			points = append(points, p)
+		}
+		if i%50 == 0 {
+			checkIncremental(t, c)
+			checkIncrementalReference(t, c)
+		}
+	}
+	checkIncremental(t, c)
+}
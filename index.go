@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SpatialIndex answers the neighborhood queries of the clustering
+// It holds locations like the BSPTree: points at the same position share a location (and its id), cnt counts them.
+// When it is built from a tree, the locations are the ones of the tree the jobs are cut from (same points and ids).
+type SpatialIndex interface {
+	// Adds a point of weight 1
+	Insert(p *Point)
+	// Removes a point of weight 1, returns false if there is no location at p
+	Remove(p *Point) bool
+	// Appends the locations inside r to points
+	RangeQuery(r Rect, points []BSPTreePoint) []BSPTreePoint
+	// Appends the locations within radius of p to points
+	RadiusQuery(p Point, radius float64, points []BSPTreePoint) []BSPTreePoint
+	// The k locations closest to p, nearest first (fewer when the index holds fewer)
+	KNN(p Point, k int) []BSPTreePoint
+	// Sum of the weights of the locations within radius of p, stops once the sum reaches limit
+	WeightWithin(p Point, radius float64, limit float64) float64
+	// Rect holding every location
+	Bounds() Rect
+	// Number of points
+	Size() int
+}
+
+// Names of the indexes that can be picked from the command line
+var indexNames = []string{"bsp", "grid", "rtree", "kdtree"}
+
+// Builds the index with the given name over the locations of the tree
+// The grid cells are epsilon wide, so a neighborhood query reads 9 cells.
+func newSpatialIndex(name string, bsp *BSPTree, epsilon float64) (SpatialIndex, error) {
+	switch name {
+	case "bsp":
+		return bsp, nil
+	case "grid":
+		return NewGridIndexFromTree(bsp, epsilon), nil
+	case "rtree":
+		return NewRTreeFromTree(bsp), nil
+	case "kdtree":
+		return NewKDTreeFromTree(bsp), nil
+	}
+	return nil, fmt.Errorf("unknown index %q (expected one of %v)", name, indexNames)
+}
+
+// Walks the locations of an index that may be inside r (at least all of them), until visit returns false
+type indexWalk func(r Rect, visit func(n BSPTreePoint) bool)
+
+// Square holding the circle of the radius around p
+func radiusRect(p Point, radius float64) Rect {
+	return Rect{p.x - radius, p.y - radius, radius * 2, radius * 2}
+}
+
+// RangeQuery of an index that can walk its locations
+func walkRangeQuery(walk indexWalk, r Rect, points []BSPTreePoint) []BSPTreePoint {
+	walk(r, func(n BSPTreePoint) bool {
+		if rectPointIntersect(r, *n.Point) {
+			points = append(points, n)
+		}
+		return true
+	})
+	return points
+}
+
+// RadiusQuery of an index that can walk its locations
+func walkRadiusQuery(walk indexWalk, p Point, radius float64, points []BSPTreePoint) []BSPTreePoint {
+	walk(radiusRect(p, radius), func(n BSPTreePoint) bool {
+		if n.Point.Distance(p) <= radius {
+			points = append(points, n)
+		}
+		return true
+	})
+	return points
+}
+
+// WeightWithin of an index that can walk its locations
+func walkWeightWithin(walk indexWalk, p Point, radius float64, limit float64) float64 {
+	weight := 0.0
+	walk(radiusRect(p, radius), func(n BSPTreePoint) bool {
+		if n.Point.Distance(p) <= radius {
+			weight += n.weight
+		}
+		return weight < limit
+	})
+	return weight
+}
+
+// Closest locations to p found so far, nearest first, at most k
+type knnResult struct {
+	p      Point
+	k      int
+	points []BSPTreePoint
+	dists  []float64
+}
+
+func newKNNResult(p Point, k int) *knnResult {
+	return &knnResult{p: p, k: k}
+}
+
+// Keeps the location if it is closer than the k-th one
+func (r *knnResult) add(n BSPTreePoint) {
+	d := n.Point.Distance(r.p)
+	if d >= r.worst() {
+		return
+	}
+	i := sort.SearchFloat64s(r.dists, d)
+	if len(r.points) < r.k {
+		r.points = append(r.points, BSPTreePoint{})
+		r.dists = append(r.dists, 0)
+	}
+	copy(r.points[i+1:], r.points[i:])
+	copy(r.dists[i+1:], r.dists[i:])
+	r.points[i], r.dists[i] = n, d
+}
+
+// Distance of the k-th location, locations further away can be skipped (infinite until k are found)
+func (r *knnResult) worst() float64 {
+	if len(r.points) < r.k {
+		return math.Inf(1)
+	}
+	return r.dists[len(r.dists)-1]
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// Expected content of an index: count and weight of every location
+type indexReference map[Point]*BSPTreePoint
+
+func (ref indexReference) insert(p Point) {
+	if l, ok := ref[p]; ok {
+		l.cnt++
+		l.weight++
+		return
+	}
+	pp := p
+	ref[p] = &BSPTreePoint{&pp, 1, 1, 0}
+}
+
+func (ref indexReference) remove(p Point) bool {
+	l, ok := ref[p]
+	if !ok {
+		return false
+	}
+	l.cnt--
+	l.weight--
+	if l.cnt == 0 {
+		delete(ref, p)
+	}
+	return true
+}
+
+// Locations matching the filter, as sorted text so they compare whatever the order and the ids
+func (ref indexReference) locations(keep func(p Point) bool) string {
+	found := []BSPTreePoint{}
+	for p, l := range ref {
+		if keep(p) {
+			found = append(found, *l)
+		}
+	}
+	return locationsText(found)
+}
+
+func locationsText(points []BSPTreePoint) string {
+	text := make([]string, len(points))
+	for i, l := range points {
+		text[i] = fmt.Sprintf("(%v %v)x%d:%.6f", l.x, l.y, l.cnt, l.weight)
+	}
+	sort.Strings(text)
+	return fmt.Sprint(text)
+}
+
+// Indexes under test, empty
+var emptyIndexes = map[string]func() SpatialIndex{
+	"bsp":    func() SpatialIndex { return NewBSPTree(0, 0, 1, 1) },
+	"grid":   func() SpatialIndex { return NewGridIndex(3) },
+	"rtree":  func() SpatialIndex { return NewRTree() },
+	"kdtree": func() SpatialIndex { return NewKDTree() },
+}
+
+// Conformance suite shared by every index: the queries must agree with the reference
+func checkSpatialIndex(t *testing.T, name string, index SpatialIndex, ref indexReference, rng *rand.Rand) {
+	t.Helper()
+	size := 0
+	for _, l := range ref {
+		size += l.cnt
+	}
+	if index.Size() != size {
+		t.Errorf("%s: size %d instead of %d", name, index.Size(), size)
+	}
+
+	all := index.RangeQuery(index.Bounds(), nil)
+	if found, expected := locationsText(all), ref.locations(func(Point) bool { return true }); found != expected {
+		t.Errorf("%s: locations in the bounds are %s instead of %s", name, found, expected)
+	}
+	ids := make(map[int]bool)
+	for _, l := range all {
+		if ids[l.id] {
+			t.Errorf("%s: id %d is used twice", name, l.id)
+		}
+		ids[l.id] = true
+	}
+
+	for i := 0; i < 50; i++ {
+		p := Point{rng.Float64()*120 - 10, rng.Float64()*120 - 10}
+		if i%2 == 0 && len(all) > 0 { // Also on the locations
+			p = *all[rng.Intn(len(all))].Point
+		}
+
+		r := Rect{p.x, p.y, rng.Float64() * 20, rng.Float64() * 20}
+		if found, expected := locationsText(index.RangeQuery(r, nil)), ref.locations(func(q Point) bool {
+			return rectPointIntersect(r, q)
+		}); found != expected {
+			t.Errorf("%s: locations in %v are %s instead of %s", name, r, found, expected)
+		}
+
+		radius := []float64{0, 0.5, 3, 200}[i%4]
+		if found, expected := locationsText(index.RadiusQuery(p, radius, nil)), ref.locations(func(q Point) bool {
+			return q.Distance(p) <= radius
+		}); found != expected {
+			t.Errorf("%s: locations within %f of %v are %s instead of %s", name, radius, p, found, expected)
+		}
+
+		weight := 0.0
+		for q, l := range ref {
+			if q.Distance(p) <= radius {
+				weight += l.weight
+			}
+		}
+		if w := index.WeightWithin(p, radius, math.Inf(1)); math.Abs(w-weight) > 1e-9 {
+			t.Errorf("%s: weight within %f of %v is %f instead of %f", name, radius, p, w, weight)
+		}
+		if w := index.WeightWithin(p, radius, 2); w < 2 && math.Abs(w-weight) > 1e-9 { // Stops early, but never below the limit
+			t.Errorf("%s: weight within %f of %v with a limit of 2 is %f", name, radius, p, w)
+		}
+
+		k := []int{1, 5, 40, len(ref) + 1}[i%4]
+		dists := []float64{}
+		for q := range ref {
+			dists = append(dists, q.Distance(p))
+		}
+		sort.Float64s(dists)
+		if len(dists) > k {
+			dists = dists[:k]
+		}
+		nearest := index.KNN(p, k)
+		found := make([]float64, len(nearest))
+		for j, n := range nearest {
+			found[j] = n.Point.Distance(p)
+		}
+		if fmt.Sprint(found) != fmt.Sprint(dists) {
+			t.Errorf("%s: distances of the %d nearest locations of %v are %v instead of %v", name, k, p, found, dists)
+		}
+	}
+	if n := index.KNN(Point{0, 0}, 0); len(n) != 0 {
+		t.Errorf("%s: %d nearest locations for k = 0", name, len(n))
+	}
+}
+
+func TestSpatialIndexFromTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points, weights := randomDataset(rng, 2_000)
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+	ref := make(indexReference)
+	locations := bsp.Query(bsp.rect)
+	idOf := make(map[Point]int)
+	for _, l := range locations {
+		ref[*l.Point] = &BSPTreePoint{l.Point, l.cnt, l.weight, l.id}
+		idOf[*l.Point] = l.id
+	}
+
+	for _, name := range indexNames {
+		for _, epsilon := range []float64{0, 0.3, 5} {
+			index, err := newSpatialIndex(name, bsp, epsilon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSpatialIndex(t, name, index, ref, rng)
+			for _, l := range index.RangeQuery(index.Bounds(), nil) {
+				if l.id != idOf[*l.Point] {
+					t.Errorf("%s: location %v has id %d instead of the one of the tree %d", name, *l.Point, l.id, idOf[*l.Point])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestSpatialIndexInsertRemove(t *testing.T) {
+	for _, name := range indexNames {
+		rng := rand.New(rand.NewSource(2))
+		index, ref := emptyIndexes[name](), make(indexReference)
+		checkSpatialIndex(t, name+" (empty)", index, ref, rng)
+
+		points := make([]Point, 1_500)
+		for i := range points {
+			points[i] = Point{float64(rng.Intn(100)), float64(rng.Intn(100))} // Duplicates on purpose
+			if i%3 == 0 {
+				points[i] = Point{rng.Float64() * 100, rng.Float64() * 100}
+			}
+			index.Insert(&points[i])
+			ref.insert(points[i])
+		}
+		checkSpatialIndex(t, name+" (inserted)", index, ref, rng)
+
+		for _, i := range rng.Perm(len(points))[:1_000] {
+			p := points[i]
+			if index.Remove(&p) != ref.remove(p) {
+				t.Errorf("%s: removing %v does not match the reference", name, p)
+			}
+		}
+		if index.Remove(&Point{-50, -50}) {
+			t.Errorf("%s: removes a point that is not there", name)
+		}
+		checkSpatialIndex(t, name+" (removed)", index, ref, rng)
+
+		for i := 0; i < 300; i++ { // Back at removed locations and new ones
+			p := &points[rng.Intn(len(points))]
+			if i%2 == 0 {
+				p = &Point{rng.Float64() * 100, rng.Float64() * 100}
+			}
+			index.Insert(p)
+			ref.insert(*p)
+		}
+		checkSpatialIndex(t, name+" (reinserted)", index, ref, rng)
+
+		for i := range points { // Remove everything
+			for index.Remove(&points[i]) {
+				ref.remove(points[i])
+			}
+		}
+		for p := range ref {
+			for index.Remove(&p) {
+				ref.remove(p)
+			}
+		}
+		checkSpatialIndex(t, name+" (cleared)", index, ref, rng)
+	}
+}
+
+func TestNewSpatialIndex(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 1, 1)
+	for _, name := range indexNames {
+		if _, err := newSpatialIndex(name, bsp, 0.1); err != nil {
+			t.Error(err)
+		}
+		if _, ok := emptyIndexes[name]; !ok {
+			t.Errorf("Index %s is not under test", name)
+		}
+	}
+	if _, err := newSpatialIndex("quadtree", bsp, 0.1); err == nil {
+		t.Error("Unknown index name is accepted")
+	}
+}
+
+func TestEngineSpatialIndexes(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for round := 0; round < 3; round++ {
+		points, weights := randomDataset(rng, 1_500)
+		bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+		locations := bsp.Query(bsp.rect)
+		epsilon, minPts := 0.5+rng.Float64()*2, 2+rng.Intn(6)
+		expected := referenceClusters(locations, epsilon, minPts)
+		for _, name := range indexNames {
+			index, _ := newSpatialIndex(name, bsp, epsilon)
+			clusters, _ := dbscanClusters(context.Background(), bsp, index, epsilon, minPts, 100, 4, nil)
+			if !checkClusters(t, locations, clusters, expected, epsilon, minPts) {
+				t.Logf("Index %s, epsilon %f, minPts %d", name, epsilon, minPts)
+			}
+		}
+	}
+}
+
+// Points of data.csv when it is there, the generated benchmark points otherwise, with the name of the dataset
+func benchmarkIndexPoints() (string, []Point, []float64) {
+	if _, err := os.Stat("./data.csv"); err == nil {
+		_, points, weights := readWeightedCSV("./data.csv", -1)
+		return "data.csv", points, weights
+	}
+	return "generated", benchmarkPoints(100_000), nil
+}
+
+// Compares the indexes at several densities: epsilon is a multiple of the average spacing of the points
+func BenchmarkSpatialIndex(b *testing.B) {
+	data, points, weights := benchmarkIndexPoints()
+	bounds := pointsBounds(points)
+	bsp := NewBSPTreeFromWeightedPoints(bounds, &points, weights)
+	spacing := math.Sqrt(bounds.w * bounds.h / float64(len(points)))
+
+	for _, k := range []float64{0.5, 2, 8} {
+		epsilon := spacing * k
+		for _, name := range indexNames {
+			index, _ := newSpatialIndex(name, bsp, epsilon)
+			b.Run(fmt.Sprint("data=", data, "/eps=", k, "x/index=", name, "/query"), func(b *testing.B) {
+				neighbors := []BSPTreePoint{}
+				for i := 0; i < b.N; i++ {
+					neighbors = index.RadiusQuery(points[i%len(points)], epsilon, neighbors[:0])
+				}
+			})
+			b.Run(fmt.Sprint("data=", data, "/eps=", k, "x/index=", name, "/knn"), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					index.KNN(points[i%len(points)], 5)
+				}
+			})
+			b.Run(fmt.Sprint("data=", data, "/eps=", k, "x/index=", name, "/dbscan"), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					dbscanParallel(context.Background(), bsp, index, epsilon, 5, 0, 1, nil)
+				}
+			})
+		}
+	}
+}
+
+// Reading every cell of the grid must not follow the map order, so that the clusters come out the same every run
+func TestGridIndexOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	points, weights := randomDataset(rng, 1_000)
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+	epsilon, minPts := 1.0, 4
+
+	var firstQuery, firstClusters string
+	for run := 0; run < 5; run++ {
+		grid := NewGridIndexFromTree(bsp, epsilon)
+		far := grid.Bounds()
+		far.x, far.y, far.w, far.h = far.x-1e6, far.y-1e6, far.w+2e6, far.h+2e6 // More cells than the grid has
+		query := fmt.Sprint(grid.RangeQuery(far, nil))
+		clusters, _ := dbscanClusters(context.Background(), bsp, grid, epsilon, minPts, 100, 1, nil)
+		text := fmt.Sprint(clusters)
+		if run == 0 {
+			firstQuery, firstClusters = query, text
+			continue
+		}
+		if query != firstQuery {
+			t.Errorf("Run %d: the locations of the grid came in another order", run)
+		}
+		if text != firstClusters {
+			t.Errorf("Run %d: the clusters came in another order", run)
+		}
+	}
+}
+
+// Queries covering more cells than the grid has read every cell, they have to be safe to run from several workers (go test -race)
+func TestGridIndexParallel(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	points, weights := randomDataset(rng, 500)
+	for i := range points {
+		points[i].x, points[i].y = math.Mod(math.Abs(points[i].x), 2), math.Mod(math.Abs(points[i].y), 2) // A few cells only
+	}
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+	locations := bsp.Query(bsp.rect)
+	epsilon, minPts := 1.0, 4
+	expected := referenceClusters(locations, epsilon, minPts)
+
+	grid := NewGridIndexFromTree(bsp, epsilon)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			grid.RadiusQuery(Point{0, 0}, 1, nil)
+			grid.WeightWithin(Point{1, 1}, 1, math.Inf(1))
+		}()
+	}
+	wg.Wait()
+
+	clusters, _ := dbscanClusters(context.Background(), bsp, grid, epsilon, minPts, 50, 4, nil)
+	checkClusters(t, locations, clusters, expected, epsilon, minPts)
+}
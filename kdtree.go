@@ -0,0 +1,200 @@
+package main
+
+import "sort"
+
+// KDTree is a 2d spatial index of locations, every node splits its subtree on x or y in turn
+// Built from a tree, every node is the median of its subtree so the tree is balanced. Inserts add leaves
+// where a search would look, removed locations stay in the tree (with no points) to keep splitting it.
+type KDTree struct {
+	root   *kdNode
+	size   int
+	ids    int  // Ids handed out so far
+	bounds Rect // Holds every location inserted so far, it does not shrink on removal
+}
+
+type kdNode struct {
+	point       BSPTreePoint // cnt is 0 once every point of the location is removed
+	byY         bool         // The left subtree has the lower (or equal) x, or y on every other level
+	left, right *kdNode
+}
+
+// Create a new empty KDTree
+func NewKDTree() *KDTree {
+	return &KDTree{}
+}
+
+// Create a new KDTree holding the locations of a tree (with their ids)
+func NewKDTreeFromTree(bsp *BSPTree) *KDTree {
+	points := bsp.Query(bsp.rect)
+	t := &KDTree{size: bsp.size, ids: bsp.IDs(), bounds: bsp.rect}
+	t.root = kdBuild(points, false)
+	return t
+}
+
+// Builds a balanced subtree, the median of the points is the root
+func kdBuild(points []BSPTreePoint, byY bool) *kdNode {
+	if len(points) == 0 {
+		return nil
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return kdCoord(*points[i].Point, byY) < kdCoord(*points[j].Point, byY)
+	})
+	m := len(points) / 2
+	return &kdNode{
+		point: points[m],
+		byY:   byY,
+		left:  kdBuild(points[:m], !byY),
+		right: kdBuild(points[m+1:], !byY),
+	}
+}
+
+func kdCoord(p Point, byY bool) float64 {
+	if byY {
+		return p.y
+	}
+	return p.x
+}
+
+// Location at p (removed or not), nil if there is none
+// Points on the split can be on both sides, the ones of a balanced tree are.
+func (n *kdNode) find(p *Point) *kdNode {
+	if n == nil {
+		return nil
+	}
+	if pointIntersect(*n.point.Point, *p) {
+		return n
+	}
+	c, split := kdCoord(*p, n.byY), kdCoord(*n.point.Point, n.byY)
+	if c <= split {
+		if found := n.left.find(p); found != nil {
+			return found
+		}
+	}
+	if c >= split {
+		return n.right.find(p)
+	}
+	return nil
+}
+
+// KDTree insert
+func (t *KDTree) Insert(p *Point) {
+	if t.size == 0 {
+		t.bounds = Rect{p.x, p.y, 0, 0}
+	} else {
+		t.bounds = t.bounds.MergePoint(*p)
+	}
+	t.size++
+
+	if n := t.root.find(p); n != nil {
+		if n.point.cnt == 0 { // Removed location, back in use
+			n.point.weight = 0
+		}
+		n.point.cnt++
+		n.point.weight++
+		return
+	}
+	leaf := &kdNode{point: BSPTreePoint{p, 1, 1, t.ids}}
+	t.ids++
+	if t.root == nil {
+		t.root = leaf
+		return
+	}
+	for n := t.root; ; {
+		next := &n.right
+		if kdCoord(*p, n.byY) < kdCoord(*n.point.Point, n.byY) {
+			next = &n.left
+		}
+		if *next == nil {
+			leaf.byY = !n.byY
+			*next = leaf
+			return
+		}
+		n = *next
+	}
+}
+
+// KDTree remove
+func (t *KDTree) Remove(p *Point) bool {
+	n := t.root.find(p)
+	if n == nil || n.point.cnt == 0 {
+		return false
+	}
+	n.point.cnt--
+	n.point.weight--
+	t.size--
+	return true
+}
+
+// Calls visit on the locations of the subtrees intersecting r, until visit returns false
+func (t *KDTree) walk(r Rect, visit func(n BSPTreePoint) bool) {
+	t.root.walk(r, visit)
+}
+
+func (n *kdNode) walk(r Rect, visit func(n BSPTreePoint) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.point.cnt > 0 && !visit(n.point) {
+		return false
+	}
+	split := kdCoord(*n.point.Point, n.byY)
+	low, high := r.x, r.x+r.w
+	if n.byY {
+		low, high = r.y, r.y+r.h
+	}
+	if low <= split && !n.left.walk(r, visit) {
+		return false
+	}
+	if high >= split && !n.right.walk(r, visit) {
+		return false
+	}
+	return true
+}
+
+func (t *KDTree) RangeQuery(r Rect, points []BSPTreePoint) []BSPTreePoint {
+	return walkRangeQuery(t.walk, r, points)
+}
+
+func (t *KDTree) RadiusQuery(p Point, radius float64, points []BSPTreePoint) []BSPTreePoint {
+	return walkRadiusQuery(t.walk, p, radius, points)
+}
+
+func (t *KDTree) WeightWithin(p Point, radius float64, limit float64) float64 {
+	return walkWeightWithin(t.walk, p, radius, limit)
+}
+
+// Searches the side of p first, the other side only if the split is closer than the k-th location found so far
+func (t *KDTree) KNN(p Point, k int) []BSPTreePoint {
+	if k <= 0 {
+		return nil
+	}
+	res := newKNNResult(p, k)
+	t.root.knn(res)
+	return res.points
+}
+
+func (n *kdNode) knn(res *knnResult) {
+	if n == nil {
+		return
+	}
+	if n.point.cnt > 0 {
+		res.add(n.point)
+	}
+	diff := kdCoord(res.p, n.byY) - kdCoord(*n.point.Point, n.byY)
+	near, far := n.left, n.right
+	if diff >= 0 {
+		near, far = far, near
+	}
+	near.knn(res)
+	if diff*diff <= res.worst()*res.worst() {
+		far.knn(res)
+	}
+}
+
+func (t *KDTree) Bounds() Rect {
+	return t.bounds
+}
+
+func (t *KDTree) Size() int {
+	return t.size
+}
@@ -1,143 +1,179 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"time"
 )
 
 func main() {
-	// Fancy progress bar :)
-	// (or deadlock sanity check)
-	progressI := 0
-	progressBar := []string{
-		"░█████████",
-		"█░████████",
-		"██░███████",
-		"███░██████",
-		"████░█████",
-		"█████░████",
-		"██████░███",
-		"███████░██",
-		"████████░█",
-		"█████████░"}
+	// Subcommands have their own arguments
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	dbscanCommand(os.Args[1:])
+}
+
+// Default mode: DBSCAN of a CSV file (or of a saved tree) on the parallel engine
+func dbscanCommand(arguments []string) {
+	// Flags go before the positional arguments
+	flags := flag.NewFlagSet("dbscan", flag.ExitOnError)
+	weightColumn := flags.Int("weightColumn", -1, "index of the CSV column holding the weight of each point (default: every point weighs 1)")
+	timeout := flags.Duration("timeout", 0, "stop clustering after this long and save the clusters found so far (default: no limit)")
+	progressMode := flags.String("progress", "bar", "how progress is shown: bar, quiet (errors only) or json (one event per line on stdout)")
+	modelFile := flags.String("model", "", "also save the clustering as a model file, to label new points with the predict command")
+	indexName := flags.String("index", "bsp", "spatial index of the neighborhood queries: bsp, grid, rtree or kdtree")
+	flags.Parse(arguments)
+	args := flags.Args()
 
 	// Defaults
 	inputFile := "./data.csv"
 	epsilon := 0.0003
 	minPts := 5
 	threadN := runtime.NumCPU() // Number of CPU cores
-	maxJobSize := 1_000
+	maxJobSize := 0             // Let the scheduler size the jobs
 
 	// If no arguments are given, print help
-	if len(os.Args) == 1 {
-		fmt.Println("Usage:   ./dbscan <input_file> <epsilon> <minPts> <maxJobSize> <threadN>")
-		fmt.Println("Example: ./dbscan ./data.csv   0.0003    5        1000         12")
+	if len(args) == 0 {
+		fmt.Println("Usage:   ./dbscan [flags] <input_file> <epsilon> <minPts> <maxJobSize> <threadN>")
+		fmt.Println("Example: ./dbscan ./data.csv   0.0003    5        0            12")
 		fmt.Println("Note:    maxJobSize is the maximum number of points that can be processed by a single job in the thread pool")
+		fmt.Println("         0 lets the scheduler split the jobs by their estimated cost, idle workers steal the jobs of the busy ones")
+		fmt.Println("         minPts is compared to the sum of the weights of the points (see -weightColumn)")
 		fmt.Println("         threadN defaults to the number of logical cores on the machine (so you probably can leave it empty)")
+		fmt.Println("         input_file can also be a tree saved by ./dbscan index, which loads faster than the CSV file")
 		fmt.Println("         Other than that, the values are defaulted to the example above")
-		fmt.Println("         If you're not feeling like going for a coffee break, you can try using a smaller epsilon or maxJobSize")
+		fmt.Println("         If you're not feeling like going for a coffee break, you can try using a smaller epsilon")
+		fmt.Println()
+		fmt.Println("Flags:")
+		flags.PrintDefaults()
+		fmt.Println()
+		fmt.Println("Other modes: ./dbscan <command> -h")
+		for _, name := range commandNames() {
+			fmt.Printf("         %-8s %s\n", name, commandHelp[name])
+		}
+	}
+
+	// Informational output, kept off stdout when it is not meant for a terminal
+	out := io.Writer(os.Stdout)
+	var progress func(Progress)
+	switch *progressMode {
+	case "bar":
+		progress = progressBar(os.Stdout)
+	case "quiet":
+		out = io.Discard
+	case "json":
+		out = io.Discard
+		progress = progressJSON(os.Stdout)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown progress mode:", *progressMode, "(expected bar, quiet or json)")
+		os.Exit(2)
 	}
 
 	// Try get input file
-	if len(os.Args) > 1 {
-		inputFile = os.Args[1]
+	if len(args) > 0 {
+		inputFile = args[0]
 	}
 	// Try get epsilon
-	if len(os.Args) > 2 {
-		epsilon, _ = strconv.ParseFloat(os.Args[2], 64)
+	if len(args) > 1 {
+		epsilon, _ = strconv.ParseFloat(args[1], 64)
 	}
 	// Try get minPts
-	if len(os.Args) > 3 {
-		minPts, _ = strconv.Atoi(os.Args[3])
+	if len(args) > 2 {
+		minPts, _ = strconv.Atoi(args[2])
 	}
 	// Try get maxJobSize
-	if len(os.Args) > 4 {
-		maxJobSize, _ = strconv.Atoi(os.Args[4])
+	if len(args) > 3 {
+		maxJobSize, _ = strconv.Atoi(args[3])
 	}
 	// Try get threadN
-	if len(os.Args) > 5 {
-		threadN, _ = strconv.Atoi(os.Args[5])
+	if len(args) > 4 {
+		threadN, _ = strconv.Atoi(args[4])
 	}
 
 	// Print settings
-	fmt.Println()
-	fmt.Println("Current settings:")
-	fmt.Println("Input file:", inputFile)
-	fmt.Println("Epsilon:", epsilon)
-	fmt.Println("MinPts:", minPts)
-	fmt.Println("MaxJobSize:", maxJobSize)
-	fmt.Println("ThreadN:", threadN)
-	fmt.Println()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Current settings:")
+	fmt.Fprintln(out, "Input file:", inputFile)
+	fmt.Fprintln(out, "Epsilon:", epsilon)
+	fmt.Fprintln(out, "MinPts:", minPts)
+	fmt.Fprintln(out, "MaxJobSize:", maxJobSize)
+	fmt.Fprintln(out, "ThreadN:", threadN)
+	fmt.Fprintln(out, "Index:", *indexName)
+	if *weightColumn >= 0 {
+		fmt.Fprintln(out, "WeightColumn:", *weightColumn)
+	}
+	fmt.Fprintln(out)
 
 	startT := time.Now() // For benchmark only
-	checkPointT := startT
-
-	// Read the CSV file and return a list of points and a bounding box
-	fmt.Println("Reading file...")
-	rect, points := readCSV(inputFile)
-	// Starts a new binary space partition for speed-up querying
-	fmt.Println("Building BSP tree...")
-	bsp := NewBSPTreeFromPoints(rect, &points)
-	fmt.Println("Starting DBSCAN...")
-
-	clustersResult := []Cluster{}
-	for cluster := range dbscanParallel(bsp, epsilon, maxJobSize, threadN) {
-		// Calculate cluster size
-		clusterSize := 0
-		for i := 0; i < len(cluster.points); i++ {
-			clusterSize += cluster.points[i].cnt // cnt is the number of points in the coordinate
-		}
 
-		// If cluster size greater than or equal to minPts, add to result
-		if clusterSize >= minPts {
-			clustersResult = append(clustersResult, cluster)
-		}
+	// Read the CSV file (or the tree saved by the index command)
+	bsp := readInputTree(inputFile, *weightColumn, out)
+	index, err := newSpatialIndex(*indexName, bsp, epsilon)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Fprintln(out, "Starting DBSCAN... (Ctrl+C to stop and save the clusters found so far)")
 
-		// Print progress
-		fmt.Print(progressBar[progressI], " Clusters: ", len(clustersResult), "\033[G") // Print progress bar and move cursor to beginning of line
-		progressI++
-		if progressI >= len(progressBar) {
-			progressI = 0
-		}
+	// Stop on Ctrl+C or after the timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
-	fmt.Println("▓▓▓▓▓▓▓▓▓▓ Clusters found:", len(clustersResult), "| ΔT:", time.Since(startT), " + 0s |")
-	checkPointT = time.Now()
-
-	// Merge clusters
-	fmt.Println("Merging clusters...")
-
-	// Fake progress bar
-	progressI = 0
-	done := false
-	go func() {
-		for {
-			fmt.Print(progressBar[progressI], " Merging...\033[G") // Print progress bar and move cursor to beginning of line
-			progressI++
-			time.Sleep(time.Millisecond * 100)
-			// Wrap progress bar
-			if progressI >= len(progressBar) {
-				progressI = 0
-			}
-			if done {
-				break
-			}
+
+	// Keep the worker stats of the last event
+	var workers []WorkerStats
+	if progress != nil {
+		report := progress
+		progress = func(p Progress) {
+			workers = p.Workers
+			report(p)
 		}
-	}()
+	}
 
-	clustersResult = mergeClusters(clustersResult, epsilon)
-	done = true // Stop fake progress bar
-	time.Sleep(time.Millisecond * 250)
-	// Print len of merged clusters
-	fmt.Println("▓▓▓▓▓▓▓▓▓▓ Merged clusters:", len(clustersResult), "| ΔT:", time.Since(startT), " + ", time.Since(checkPointT), "|")
-	checkPointT = time.Now()
+	reporter := newProgressReporter(progress)
+	state := dbscanParallel(ctx, bsp, index, epsilon, minPts, maxJobSize, threadN, reporter)
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Stopped early ("+err.Error()+"), only the clusters found so far are saved")
+	}
+	stop() // A second Ctrl+C quits right away
+	checkPointT := time.Now()
+
+	// One cluster per set of core points
+	clustersResult := state.clusters(index, reporter)
+	reporter.update(func(p *Progress) {
+		p.Phase = PhaseDone
+		p.Clusters = len(clustersResult)
+	})
+	fmt.Fprintln(out, "Clusters:", len(clustersResult), "| ΔT:", time.Since(startT), "| Merging:", time.Since(checkPointT))
+	for i, w := range workers {
+		fmt.Fprintf(out, "Worker %d: %3.0f%% busy | Jobs: %d (%d stolen, %d split) | Points: %d\n",
+			i, w.Utilization*100, w.Jobs, w.Steals, w.Splits, w.Points)
+	}
 
-	fmt.Println("Saving results...")
+	fmt.Fprintln(out, "Saving results...")
 	// Write clusters to file
 	writeCSV("./clusters.csv", clustersResult)
 	// Write points to file
 	writeClusterPoints("./points.csv", clustersResult)
-	fmt.Println("Total elapsed time:", time.Since(startT))
+	// Write the model, after the files sort the clusters so the labels match points.csv
+	if *modelFile != "" {
+		if err := SaveModel(*modelFile, newModel(state, clustersResult, epsilon, minPts)); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}
+	fmt.Fprintln(out, "Total elapsed time:", time.Since(startT))
 }
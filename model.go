@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+)
+
+// Model is the result of a clustering that labels new points without clustering again:
+// a point within epsilon of a core point joins the cluster of the closest one, any other point is noise.
+type Model struct {
+	Epsilon  float64
+	MinPts   int
+	Clusters int      // Labels go from 1 to Clusters, 0 is noise
+	cores    *BSPTree // Core points, their neighborhoods are searched for the new points
+	labels   []int    // Cluster of every core location, by id in cores
+}
+
+// Binary format of a saved Model (little endian):
+//
+//	magic "DBSCNMDL", version uint32, epsilon float64, minPts uint64, clusters uint64, core locations uint64
+//	cluster of every core location by id, uvarint
+//	the tree of the core locations, as BSPTree.WriteTo writes it
+//	CRC-32 (Castagnoli) of everything before it, uint32
+const (
+	modelFileMagic   = "DBSCNMDL"
+	modelFileVersion = 1
+	modelFileHeader  = len(modelFileMagic) + 4 + 4*8
+)
+
+// Keeps the core points of a run, clusters[i] gets the label i + 1 (like in points.csv)
+func newModel(state *dbscanState, clusters []Cluster, epsilon float64, minPts int) *Model {
+	cores := []Point{}
+	labels := []int{}
+	for i, c := range clusters {
+		for _, p := range c.points {
+			if atomic.LoadInt32(&state.core[p.id]) == 1 {
+				cores = append(cores, *p.Point)
+				labels = append(labels, i+1)
+			}
+		}
+	}
+	// The core points are distinct locations, so they get the ids in the order they are inserted
+	tree := NewBSPTreeFromPoints(pointsBounds(cores), &cores)
+	return &Model{Epsilon: epsilon, MinPts: minPts, Clusters: len(clusters), cores: tree, labels: labels}
+}
+
+// Cluster of a point (from 1), 0 for noise
+// The closest core point is picked like the clustering picks it for the border points, so the points
+// of the training data get the cluster they have in the clustering.
+func (m *Model) Predict(p Point) int {
+	var closest *BSPTreePoint
+	cores := m.cores.RadiusQuery(p, m.Epsilon, nil)
+	for i, c := range cores {
+		if closest == nil || closerTo(p, *c.Point, *closest.Point) {
+			closest = &cores[i]
+		}
+	}
+	if closest == nil {
+		return 0
+	}
+	return m.labels[closest.id]
+}
+
+// Writes the model in the binary format
+func (m *Model) WriteTo(w io.Writer) (int64, error) {
+	hash := crc32.New(binaryFileTable)
+	out := bufio.NewWriter(io.MultiWriter(w, hash))
+	buf := make([]byte, 0, modelFileHeader)
+	buf = append(buf, modelFileMagic...)
+	buf = appendUint32(buf, modelFileVersion)
+	buf = appendUint64(buf, math.Float64bits(m.Epsilon))
+	buf = appendUint64(buf, uint64(m.MinPts))
+	buf = appendUint64(buf, uint64(m.Clusters))
+	buf = appendUint64(buf, uint64(len(m.labels)))
+	for _, label := range m.labels {
+		buf = appendUvarint(buf, uint64(label))
+	}
+	written, _ := out.Write(buf)
+	k, err := m.cores.WriteTo(out)
+	written += int(k)
+	if err != nil {
+		return int64(written), err
+	}
+	if err := out.Flush(); err != nil {
+		return int64(written), err
+	}
+
+	n, err := w.Write(appendUint32(nil, hash.Sum32()))
+	return int64(written + n), err
+}
+
+// Reads a model written by WriteTo
+func ReadModel(r io.Reader) (*Model, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := checkBinaryFile(data, modelFileMagic, modelFileVersion, modelFileHeader, "model")
+	if err != nil {
+		return nil, err
+	}
+
+	d := binaryDecoder{data: body, pos: len(modelFileMagic) + 4}
+	m := &Model{Epsilon: d.float64()}
+	minPts, clusters, cores := d.uint64(), d.uint64(), d.uint64()
+	if minPts > math.MaxInt32 || clusters > math.MaxInt32 || cores > uint64(len(body)) {
+		return nil, errors.New("invalid model file header")
+	}
+	m.MinPts, m.Clusters = int(minPts), int(clusters)
+	m.labels = make([]int, cores)
+	for i := range m.labels {
+		m.labels[i] = d.uvarint()
+		if m.labels[i] < 1 || m.labels[i] > m.Clusters {
+			d.err = errors.New("invalid cluster in model file")
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+
+	tree, err := ReadBSPTree(bytes.NewReader(body[d.pos:]))
+	if err != nil {
+		return nil, fmt.Errorf("core points of the model: %w", err)
+	}
+	if tree.IDs() != len(m.labels) {
+		return nil, errors.New("the core points of the model do not match their clusters")
+	}
+	m.cores = tree
+	return m, nil
+}
+
+// Saves the model to a file
+func SaveModel(filename string, m *Model) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Loads a model saved by SaveModel
+func LoadModel(filename string) (*Model, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m, err := ReadModel(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return m, nil
+}
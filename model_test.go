@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// Model of a weighted random dataset, with the locations it was trained on
+func testModel(t *testing.T) (*Model, []BSPTreePoint, *dbscanState, []Cluster) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	points, weights := randomDataset(rng, 3_000)
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+	state := dbscanParallel(context.Background(), bsp, nil, 1.5, 4, 100, 2, nil)
+	clusters := state.clusters(bsp, nil)
+	return newModel(state, clusters, 1.5, 4), bsp.Query(bsp.rect), state, clusters
+}
+
+func TestModelPredict(t *testing.T) {
+	model, locations, state, clusters := testModel(t)
+	if model.Clusters != len(clusters) || model.Clusters == 0 {
+		t.Fatalf("Model has %d clusters instead of %d", model.Clusters, len(clusters))
+	}
+
+	label := make(map[int]int) // Cluster of every location id
+	for i, c := range clusters {
+		for _, p := range c.points {
+			label[p.id] = i + 1
+		}
+	}
+	// Every training point gets its cluster back, border points between two clusters included
+	between := 0
+	for _, p := range locations {
+		predicted := model.Predict(*p.Point)
+		if predicted != label[p.id] {
+			t.Errorf("Point %v (core: %v) is predicted in cluster %d instead of %d", *p.Point, state.core[p.id] == 1, predicted, label[p.id])
+		}
+		if state.core[p.id] == 1 {
+			continue
+		}
+		clusters := make(map[int]bool)
+		for _, n := range locationsWithin(locations, *p.Point, 1.5) {
+			if state.core[n.id] == 1 {
+				clusters[label[n.id]] = true
+			}
+		}
+		if len(clusters) > 1 {
+			between++
+		}
+	}
+	if between == 0 {
+		t.Error("No border point between two clusters, the test data does not check them")
+	}
+	if label := model.Predict(Point{-1000, -1000}); label != 0 {
+		t.Errorf("Far away point is predicted in cluster %d", label)
+	}
+}
+
+// Locations within radius of p
+func locationsWithin(locations []BSPTreePoint, p Point, radius float64) []BSPTreePoint {
+	within := []BSPTreePoint{}
+	for _, n := range locations {
+		if n.Point.Distance(p) <= radius {
+			within = append(within, n)
+		}
+	}
+	return within
+}
+
+func TestModelFileRoundTrip(t *testing.T) {
+	model, _, _, _ := testModel(t)
+	var buf bytes.Buffer
+	if n, err := model.WriteTo(&buf); err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo wrote %d bytes (%d in the buffer): %v", n, buf.Len(), err)
+	}
+	loaded, err := ReadModel(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Epsilon != model.Epsilon || loaded.MinPts != model.MinPts || loaded.Clusters != model.Clusters {
+		t.Errorf("Loaded model has epsilon %f, minPts %d and %d clusters instead of %f, %d and %d",
+			loaded.Epsilon, loaded.MinPts, loaded.Clusters, model.Epsilon, model.MinPts, model.Clusters)
+	}
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 1_000; i++ {
+		p := Point{rng.Float64() * 100, rng.Float64() * 100}
+		if loaded.Predict(p) != model.Predict(p) {
+			t.Errorf("Loaded model predicts %d for %v instead of %d", loaded.Predict(p), p, model.Predict(p))
+		}
+	}
+
+	// Corrupted files and other binary files are rejected
+	data := buf.Bytes()
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/3] ^= 0x01
+	if _, err := ReadModel(bytes.NewReader(corrupted)); !errors.Is(err, errFileChecksum) {
+		t.Errorf("Corrupted model gives %v", err)
+	}
+	var tree bytes.Buffer
+	model.cores.WriteTo(&tree)
+	if _, err := ReadModel(&tree); err == nil {
+		t.Error("A tree file is read as a model")
+	}
+	if _, err := ReadModel(bytes.NewReader(data[:modelFileHeader])); err == nil {
+		t.Error("Truncated model is accepted")
+	}
+}
+
+func TestModelEmpty(t *testing.T) {
+	bsp := NewBSPTree(0, 0, 1, 1)
+	state := dbscanParallel(context.Background(), bsp, nil, 1, 5, 0, 1, nil)
+	model := newModel(state, state.clusters(bsp, nil), 1, 5)
+
+	filename := filepath.Join(t.TempDir(), "model.bin")
+	if err := SaveModel(filename, model); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Clusters != 0 || loaded.Predict(Point{0.5, 0.5}) != 0 {
+		t.Error("Model without clusters predicts a cluster")
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// OPTICSResult is the cluster ordering of the locations in the tree
+// Everything is in the OPTICS order, undefined distances are +Inf
+type OPTICSResult struct {
+	points       []BSPTreePoint
+	reachability []float64
+	core         []float64 // Core distance (+Inf if not a core point at maxEpsilon)
+	predecessor  []int     // Position of the point the reachability comes from (-1 if none)
+}
+
+// OPTICS orders the locations so that every DBSCAN clustering with an epsilon up to maxEpsilon
+// can be extracted from it afterwards, without running the clustering again.
+// Points in the same location are processed together, every point counts as its weight.
+func optics(bsp *BSPTree, maxEpsilon float64, minPts int) OPTICSResult {
+	points := bsp.Query(bsp.rect)
+	index := make(map[*Point]int, len(points))
+	for i, p := range points {
+		index[p.Point] = i
+	}
+
+	reachability := make([]float64, len(points))
+	core := make([]float64, len(points))
+	predecessor := make([]int, len(points))
+	for i := range points {
+		reachability[i] = math.Inf(1)
+		predecessor[i] = -1
+	}
+	processed := make([]bool, len(points))
+	order := make([]int, 0, len(points))
+
+	// Neighbors within maxEpsilon, nearest first, and the core distance of the point
+	neighbors := func(i int) ([]BSPTreePoint, float64) {
+		p := points[i].Point
+		r := Rect{p.x - maxEpsilon, p.y - maxEpsilon, maxEpsilon * 2, maxEpsilon * 2}
+		if math.IsInf(maxEpsilon, 1) {
+			r = bsp.rect
+		}
+		res := []BSPTreePoint{}
+		for _, n := range bsp.Query(r) {
+			if n.Point.Distance(*p) <= maxEpsilon {
+				res = append(res, n)
+			}
+		}
+		sort.Slice(res, func(a, b int) bool {
+			return res[a].Point.Distance(*p) < res[b].Point.Distance(*p)
+		})
+
+		weight := 0.0
+		for _, n := range res {
+			weight += n.weight
+			if weight >= float64(minPts) {
+				return res, n.Point.Distance(*p)
+			}
+		}
+		return res, math.Inf(1)
+	}
+
+	for start := range points {
+		if processed[start] {
+			continue
+		}
+		seeds := &opticsSeeds{}
+		heap.Push(seeds, opticsSeed{start, math.Inf(1)})
+		for seeds.Len() > 0 {
+			i := heap.Pop(seeds).(opticsSeed).index
+			if processed[i] {
+				continue // Stale entry, the point was reached again with a smaller distance
+			}
+			processed[i] = true
+			order = append(order, i)
+
+			var n []BSPTreePoint
+			n, core[i] = neighbors(i)
+			if math.IsInf(core[i], 1) {
+				continue
+			}
+			for _, neighbor := range n {
+				j := index[neighbor.Point]
+				if processed[j] {
+					continue
+				}
+				reach := math.Max(core[i], neighbor.Point.Distance(*points[i].Point))
+				if reach < reachability[j] {
+					reachability[j] = reach
+					predecessor[j] = i
+					heap.Push(seeds, opticsSeed{j, reach})
+				}
+			}
+		}
+	}
+
+	// Put everything in order
+	position := make([]int, len(points))
+	for pos, i := range order {
+		position[i] = pos
+	}
+	res := OPTICSResult{
+		points:       make([]BSPTreePoint, len(points)),
+		reachability: make([]float64, len(points)),
+		core:         make([]float64, len(points)),
+		predecessor:  make([]int, len(points)),
+	}
+	for pos, i := range order {
+		res.points[pos] = points[i]
+		res.reachability[pos] = reachability[i]
+		res.core[pos] = core[i]
+		res.predecessor[pos] = -1
+		if predecessor[i] != -1 {
+			res.predecessor[pos] = position[predecessor[i]]
+		}
+	}
+	return res
+}
+
+// Priority queue of the points to process next, closest first
+type opticsSeed struct {
+	index        int
+	reachability float64
+}
+
+type opticsSeeds []opticsSeed
+
+func (s opticsSeeds) Len() int            { return len(s) }
+func (s opticsSeeds) Less(i, j int) bool  { return s[i].reachability < s[j].reachability }
+func (s opticsSeeds) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *opticsSeeds) Push(x interface{}) { *s = append(*s, x.(opticsSeed)) }
+func (s *opticsSeeds) Pop() interface{} {
+	old := *s
+	seed := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return seed
+}
+
+// Returns the DBSCAN clustering at epsilon (up to the OPTICS maxEpsilon), in the OPTICS order
+// Clusters start at 1, 0 means noise.
+func (res OPTICSResult) ExtractDBSCAN(epsilon float64) []int {
+	labels := make([]int, len(res.points))
+	cluster := 0
+	for i := range res.points {
+		if res.reachability[i] > epsilon { // Not reachable from the previous points
+			if res.core[i] <= epsilon {
+				cluster++
+				labels[i] = cluster
+			}
+			continue
+		}
+		labels[i] = cluster
+	}
+	return labels
+}
+
+// Returns the clusters found by the xi method (steep areas of the reachability plot), in the OPTICS order
+// xi is the minimum relative steepness (0 to 1), minPts is the one OPTICS ran with, clusters lighter than
+// minClusterSize are ignored. Clusters are nested: they are taken smallest first, and a cluster that
+// overlaps one already taken is skipped whole (like scikit-learn), so its other points stay noise.
+// Clusters start at 1, 0 means noise.
+func (res OPTICSResult) ExtractXi(xi float64, minPts int, minClusterSize int) []int {
+	labels := make([]int, len(res.points))
+	cluster := 0
+	for _, c := range res.xiClusters(xi, minPts, minClusterSize) {
+		free := true
+		for i := c[0]; i <= c[1]; i++ {
+			free = free && labels[i] == 0
+		}
+		if !free {
+			continue
+		}
+		cluster++
+		for i := c[0]; i <= c[1]; i++ {
+			labels[i] = cluster
+		}
+	}
+	return labels
+}
+
+// A steep down area, the start of a possible cluster
+type steepDownArea struct {
+	start, end int
+	mib        float64 // Maximum reachability between the end of the area and now
+}
+
+// Finds the [start, end] ranges of the xi clusters, smaller clusters first
+// Follows Ankerst et al. (1999) with the corrections from scikit-learn.
+func (res OPTICSResult) xiClusters(xi float64, minPts int, minClusterSize int) [][2]int {
+	n := len(res.points)
+	plot := append(append([]float64{}, res.reachability...), math.Inf(1)) // The plot ends on an infinite jump
+	xiComplement := 1 - xi
+
+	// Weight of the points in [start, end]
+	prefix := make([]float64, n+1)
+	for i, p := range res.points {
+		prefix[i+1] = prefix[i] + p.weight
+	}
+
+	steepUp := make([]bool, n)
+	steepDown := make([]bool, n)
+	up := make([]bool, n)
+	down := make([]bool, n)
+	for i := 0; i < n; i++ {
+		ratio := plot[i] / plot[i+1] // NaN for two infinite values, which is neither up nor down
+		steepUp[i] = ratio <= xiComplement
+		steepDown[i] = ratio >= 1/xiComplement
+		down[i] = ratio > 1
+		up[i] = ratio < 1
+	}
+
+	// End of a steep area, allowing up to minPts points that are not steep, as long as they keep going the same way
+	extend := func(steep []bool, xward []bool, start int) int {
+		nonXward := 0
+		end := start
+		for i := start; i < n; i++ {
+			if steep[i] {
+				nonXward = 0
+				end = i
+			} else if !xward[i] {
+				nonXward++
+				if nonXward > minPts {
+					break
+				}
+			} else {
+				return end
+			}
+		}
+		return end
+	}
+
+	// Drop the areas that are too low to start a cluster ending after mib
+	filter := func(sdas []steepDownArea, mib float64) []steepDownArea {
+		if math.IsInf(mib, 1) {
+			return nil
+		}
+		kept := []steepDownArea{}
+		for _, sda := range sdas {
+			if mib <= plot[sda.start]*xiComplement {
+				sda.mib = math.Max(sda.mib, mib)
+				kept = append(kept, sda)
+			}
+		}
+		return kept
+	}
+
+	clusters := [][2]int{}
+	sdas := []steepDownArea{}
+	index := 0
+	mib := 0.0
+	for steep := 0; steep < n; steep++ {
+		if !steepUp[steep] && !steepDown[steep] || steep < index {
+			continue
+		}
+		for i := index; i <= steep; i++ {
+			mib = math.Max(mib, plot[i])
+		}
+		sdas = filter(sdas, mib)
+
+		if steepDown[steep] {
+			end := extend(steepDown, up, steep)
+			sdas = append(sdas, steepDownArea{start: steep, end: end})
+			index = end + 1
+			mib = plot[index]
+			continue
+		}
+
+		upStart := steep
+		upEnd := extend(steepUp, down, upStart)
+		index = upEnd + 1
+		mib = plot[index]
+
+		found := [][2]int{}
+		for _, sda := range sdas {
+			start, end := sda.start, upEnd
+			if plot[end+1]*xiComplement < sda.mib {
+				continue
+			}
+
+			// Both sides of the cluster have to be at about the same level
+			dMax := plot[sda.start]
+			if dMax*xiComplement >= plot[end+1] {
+				for plot[start+1] > plot[end+1] && start < sda.end {
+					start++
+				}
+			} else if plot[end+1]*xiComplement >= dMax {
+				for plot[end-1] > dMax && end > upStart {
+					end--
+				}
+			}
+
+			// The last point must have been reached from inside the cluster
+			var ok bool
+			if start, end, ok = res.correctPredecessor(plot, start, end); !ok {
+				continue
+			}
+
+			if prefix[end+1]-prefix[start] < float64(minClusterSize) || start > sda.end || end < upStart {
+				continue
+			}
+			found = append(found, [2]int{start, end})
+		}
+
+		// Smaller clusters first
+		for i := len(found) - 1; i >= 0; i-- {
+			clusters = append(clusters, found[i])
+		}
+	}
+	return clusters
+}
+
+// Shrinks the end of a cluster until its last point was reached from inside the cluster
+func (res OPTICSResult) correctPredecessor(plot []float64, start int, end int) (int, int, bool) {
+	for start < end {
+		if plot[start] > plot[end] {
+			return start, end, true
+		}
+		if p := res.predecessor[end]; p >= start && p < end {
+			return start, end, true
+		}
+		end--
+	}
+	return 0, 0, false
+}
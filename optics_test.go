@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// Random blobs of varying density plus some noise
+func opticsTestPoints(rng *rand.Rand) []Point {
+	points := []Point{}
+	for i, blob := range []struct{ x, y, spread float64 }{{0, 0, 0.3}, {10, 0, 1}, {0, 10, 0.5}} {
+		for j := 0; j < 150+50*i; j++ {
+			points = append(points, Point{blob.x + rng.NormFloat64()*blob.spread, blob.y + rng.NormFloat64()*blob.spread})
+		}
+	}
+	for i := 0; i < 30; i++ {
+		points = append(points, Point{-10 + rng.Float64()*30, -10 + rng.Float64()*30})
+	}
+	return points
+}
+
+func TestOPTICSExtractDBSCAN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := opticsTestPoints(rng)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	res := optics(bsp, math.Inf(1), 5)
+	if len(res.points) != len(points) || !math.IsInf(res.reachability[0], 1) {
+		t.Fatal("Ordering is not correct")
+	}
+
+	for _, epsilon := range []float64{0.2, 0.5, 1} {
+		labels := res.ExtractDBSCAN(epsilon)
+		coords := make([]PointN, len(res.points))
+		for i, p := range res.points {
+			coords[i] = PointN{p.x, p.y}
+		}
+		expected := dbscanN(coords, nil, epsilon, 5, 2)
+
+		// Core points must be clustered exactly like DBSCAN, border points may end up as noise in OPTICS
+		mapping := make(map[int]int)
+		for i, p := range coords {
+			if len(dbscanNeighbors(coords, p, epsilon)) < 5 {
+				if labels[i] != 0 && expected[i] == 0 {
+					t.Fatalf("Noise point %d is in cluster %d", i, labels[i])
+				}
+				continue
+			}
+			if m, ok := mapping[labels[i]]; labels[i] == 0 || ok && m != expected[i] {
+				t.Fatalf("Core point %d has label %d, DBSCAN gave %d", i, labels[i], expected[i])
+			}
+			mapping[labels[i]] = expected[i]
+		}
+		if clusters, _ := labelSummary(labels); clusters != len(mapping) {
+			t.Errorf("Found %d clusters instead of %d", clusters, len(mapping))
+		}
+	}
+}
+
+func dbscanNeighbors(points []PointN, p PointN, epsilon float64) []int {
+	res := []int{}
+	for i, q := range points {
+		if p.Distance(q) <= epsilon {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+func TestOPTICSExtractXi(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	points := opticsTestPoints(rng)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	res := optics(bsp, math.Inf(1), 10)
+	labels := res.ExtractXi(0.05, 10, 50)
+
+	// Each blob mostly ends up in a cluster of its own
+	majority := []int{}
+	for _, blob := range []Point{{0, 0}, {10, 0}, {0, 10}} {
+		votes := map[int]int{}
+		total := 0
+		for i, p := range res.points {
+			if p.Distance(blob) < 1 {
+				votes[labels[i]]++
+				total++
+			}
+		}
+		best, label := 0, 0
+		for l, n := range votes {
+			if l != 0 && n > best {
+				best, label = n, l
+			}
+		}
+		if best < total*7/10 {
+			t.Errorf("Blob %v is not clustered together: %v", blob, votes)
+		}
+		for _, other := range majority {
+			if other == label {
+				t.Errorf("Blob %v shares its cluster with another blob", blob)
+			}
+		}
+		majority = append(majority, label)
+	}
+}
+
+func TestOPTICSCSV(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	points := opticsTestPoints(rng)
+	points = append(points, points[0], points[0]) // Duplicates are kept as a count
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	res := optics(bsp, 2, 5)
+
+	filename := filepath.Join(t.TempDir(), "reachability.csv")
+	writeOPTICSCSV(filename, res)
+	loaded := readOPTICSCSV(filename)
+	if len(loaded.points) != len(res.points) {
+		t.Fatalf("Loaded %d points instead of %d", len(loaded.points), len(res.points))
+	}
+	for i := range res.points {
+		if *loaded.points[i].Point != *res.points[i].Point || loaded.points[i].cnt != res.points[i].cnt ||
+			loaded.reachability[i] != res.reachability[i] || loaded.core[i] != res.core[i] ||
+			loaded.predecessor[i] != res.predecessor[i] {
+			t.Fatalf("Position %d was not saved correctly", i)
+		}
+	}
+
+	expected := res.ExtractXi(0.1, 5, 20)
+	for i, label := range loaded.ExtractXi(0.1, 5, 20) {
+		if label != expected[i] {
+			t.Fatal("Extraction from the file does not match")
+		}
+	}
+}
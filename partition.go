@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// PartitionTree is an N-dimensional spatial index of PointN objects.
+// Like the BSPTree it keeps splitting space in two, but it splits on the widest dimension
+// of its points (relative to a scale per dimension), at the median.
+type PartitionTree struct {
+	root   *partitionNode
+	points []PointN
+}
+
+type partitionNode struct {
+	box         Box   // Bounds of the points in the node
+	size        int   // Number of points in the node
+	items       []int // Indices of the points, only on leaves
+	left, right *partitionNode
+}
+
+// Leaves are not split further once they are this small
+const partitionLeafSize = 8
+
+// Builds the tree, scale is the size of one unit in each dimension (nil means 1 everywhere)
+// Mixing dimensions such as space and time needs a scale, otherwise the split would always be on the biggest unit.
+func NewPartitionTree(points []PointN, scale PointN) *PartitionTree {
+	tree := &PartitionTree{points: points}
+	if len(points) == 0 {
+		return tree
+	}
+	if scale == nil {
+		scale = make(PointN, len(points[0]))
+		for d := range scale {
+			scale[d] = 1
+		}
+	}
+
+	items := make([]int, len(points))
+	for i := range items {
+		items[i] = i
+	}
+	tree.root = tree.build(items, scale)
+	return tree
+}
+
+func (tree *PartitionTree) build(items []int, scale PointN) *partitionNode {
+	bounds := make([]PointN, len(items))
+	for i, item := range items {
+		bounds[i] = tree.points[item]
+	}
+	node := &partitionNode{box: pointsBox(bounds), size: len(items)}
+
+	// Split on the widest dimension
+	dim, width := 0, 0.0
+	for d := range scale {
+		if w := (node.box.max[d] - node.box.min[d]) / scale[d]; w > width {
+			dim, width = d, w
+		}
+	}
+	if len(items) <= partitionLeafSize || width == 0 { // Small enough, or every point is in the same place
+		node.items = items
+		return node
+	}
+
+	// Median split, equal values stay on the same side
+	sort.Slice(items, func(i, j int) bool {
+		return tree.points[items[i]][dim] < tree.points[items[j]][dim]
+	})
+	mid := len(items) / 2
+	for mid > 0 && tree.points[items[mid-1]][dim] == tree.points[items[mid]][dim] {
+		mid--
+	}
+	if mid == 0 {
+		mid = len(items) / 2
+		for mid < len(items) && tree.points[items[mid-1]][dim] == tree.points[items[mid]][dim] {
+			mid++
+		}
+	}
+
+	node.left = tree.build(items[:mid], scale)
+	node.right = tree.build(items[mid:], scale)
+	return node
+}
+
+// Subtree of the PartitionTree, a job of the DBSCAN engine (see dbscanN)
+// weights may be nil, every point then counts once.
+type partitionJob struct {
+	node    *partitionNode
+	tree    *PartitionTree
+	weights []float64
+}
+
+func (j partitionJob) size() int {
+	return j.node.size
+}
+
+// Same estimate as jobCost: every point queries its neighbors, assuming they are spread evenly over the node box
+func (j partitionJob) cost(epsilon float64) float64 {
+	size := float64(j.node.size)
+	neighbors := size
+	for d := range j.node.box.min {
+		if w := j.node.box.max[d] - j.node.box.min[d]; w > 2*epsilon {
+			neighbors *= 2 * epsilon / w
+		}
+	}
+	return size * (1 + neighbors)
+}
+
+func (j partitionJob) halves() (dbscanJob, dbscanJob) {
+	if j.node.left == nil {
+		return nil, nil
+	}
+	return partitionJob{j.node.left, j.tree, j.weights}, partitionJob{j.node.right, j.tree, j.weights}
+}
+
+func (j partitionJob) run(ctx context.Context, state *dbscanState, epsilon float64, minPts int) int {
+	return dbscanPartition(ctx, j, state, epsilon, minPts)
+}
+
+// Number of points in the tree
+func (tree *PartitionTree) Size() int {
+	return len(tree.points)
+}
+
+// Returns the indices of the points inside the box
+func (tree *PartitionTree) Query(b Box) []int {
+	res := []int{}
+	stack := []*partitionNode{tree.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == nil || !node.box.Intersects(b) {
+			continue
+		}
+		if node.left == nil {
+			for _, i := range node.items {
+				if b.Contains(tree.points[i]) {
+					res = append(res, i)
+				}
+			}
+			continue
+		}
+		stack = append(stack, node.left, node.right)
+	}
+	return res
+}
+
+// Returns the indices of the points within radius of p (Euclidean distance)
+func (tree *PartitionTree) Radius(p PointN, radius float64) []int {
+	r := make(PointN, len(p))
+	for d := range r {
+		r[d] = radius
+	}
+	res := []int{}
+	for _, i := range tree.Query(boxAround(p, r)) {
+		if tree.points[i].Distance(p) <= radius {
+			res = append(res, i)
+		}
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPartitionTreeQuery(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, dims := range []int{1, 3, 5} {
+		points := make([]PointN, 2000)
+		for i := range points {
+			points[i] = make(PointN, dims)
+			for d := range points[i] {
+				points[i][d] = float64(rng.Intn(50)) // Duplicates on purpose
+			}
+		}
+		tree := NewPartitionTree(points, nil)
+		if tree.Size() != len(points) {
+			t.Error("Tree does not have the correct number of points")
+		}
+
+		for i := 0; i < 100; i++ {
+			p := points[rng.Intn(len(points))]
+			radius := rng.Float64() * 20
+			expected := 0
+			for _, q := range points {
+				if p.Distance(q) <= radius {
+					expected++
+				}
+			}
+			if n := tree.Radius(p, radius); len(n) != expected {
+				t.Fatalf("%dD radius query returned %d points instead of %d", dims, len(n), expected)
+			}
+		}
+
+		everything := tree.Query(pointsBox(points))
+		if len(everything) != len(points) {
+			t.Errorf("%dD query returned %d points instead of %d", dims, len(everything), len(points))
+		}
+	}
+}
+
+func TestPartitionTreeEmpty(t *testing.T) {
+	tree := NewPartitionTree(nil, nil)
+	if tree.Size() != 0 || len(tree.Radius(PointN{0, 0}, 1)) != 0 {
+		t.Error("Empty tree returned points")
+	}
+}
@@ -14,6 +14,17 @@ func (p Point) Distance(q Point) float64 {
 	return math.Sqrt(dx*dx + dy*dy)
 }
 
+// Calculates the average of a list of points where each point has a weight
+func pointWeightedAverage(points []Point, weights []float64) Point {
+	var x, y, total float64
+	for i, p := range points {
+		x += p.x * weights[i]
+		y += p.y * weights[i]
+		total += weights[i]
+	}
+	return Point{x / total, y / total}
+}
+
 // Calculates the pointAverage of a list of points
 func pointAverage(points []Point) Point {
 	var x, y float64
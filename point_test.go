@@ -21,3 +21,17 @@ func TestPointDistance(t *testing.T) {
 		}
 	}
 }
+
+func TestPointWeightedAverage(t *testing.T) {
+	p := pointWeightedAverage([]Point{{0, 0}, {10, 4}}, []float64{3, 1})
+	if p.x != 2.5 || p.y != 1 {
+		t.Errorf("Weighted average failed: %v", p)
+	}
+
+	// Same weights is the plain average
+	points := []Point{{1, 2}, {3, 5}, {-4, 8}}
+	p = pointWeightedAverage(points, []float64{2, 2, 2})
+	if p != pointAverage(points) {
+		t.Errorf("Weighted average failed: %v", p)
+	}
+}
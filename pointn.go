@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+// PointN is a point with any number of dimensions
+type PointN []float64
+
+func (p PointN) Distance(q PointN) float64 {
+	d := 0.0
+	for i := range p {
+		diff := p[i] - q[i]
+		d += diff * diff
+	}
+	return math.Sqrt(d)
+}
+
+// Box is an axis aligned box in N dimensions (the N-dimensional Rect)
+type Box struct {
+	min, max PointN
+}
+
+// Returns the bounding box of a list of points
+func pointsBox(points []PointN) Box {
+	if len(points) == 0 {
+		return Box{}
+	}
+	b := Box{append(PointN{}, points[0]...), append(PointN{}, points[0]...)}
+	for _, p := range points[1:] {
+		for d := range p {
+			b.min[d] = math.Min(b.min[d], p[d])
+			b.max[d] = math.Max(b.max[d], p[d])
+		}
+	}
+	return b
+}
+
+// Returns a box of the given radius around a point
+func boxAround(p PointN, radius PointN) Box {
+	b := Box{make(PointN, len(p)), make(PointN, len(p))}
+	for d := range p {
+		b.min[d] = p[d] - radius[d]
+		b.max[d] = p[d] + radius[d]
+	}
+	return b
+}
+
+// Box to box collision detection
+func (b Box) Intersects(other Box) bool {
+	for d := range b.min {
+		if b.min[d] > other.max[d] || b.max[d] < other.min[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// Box to point collision detection
+func (b Box) Contains(p PointN) bool {
+	for d := range b.min {
+		if p[d] < b.min[d] || p[d] > b.max[d] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPointNDistance(t *testing.T) {
+	p := PointN{1, 2, 3}
+	q := PointN{4, 6, 3}
+	if p.Distance(q) != 5 {
+		t.Errorf("Distance failed: %v", p.Distance(q))
+	}
+	if p.Distance(p) != 0 {
+		t.Error("Distance to itself is not 0")
+	}
+
+	// Same as the 2D point
+	if (PointN{1, 1}).Distance(PointN{2, 3}) != (Point{1, 1}).Distance(Point{2, 3}) {
+		t.Error("Distance does not match the 2D distance")
+	}
+}
+
+func TestBox(t *testing.T) {
+	b := pointsBox([]PointN{{0, 5, -1}, {2, 1, 1}, {1, 3, 0}})
+	for d, v := range []float64{0, 1, -1} {
+		if b.min[d] != v {
+			t.Errorf("Box min failed: %v", b.min)
+		}
+	}
+	for d, v := range []float64{2, 5, 1} {
+		if b.max[d] != v {
+			t.Errorf("Box max failed: %v", b.max)
+		}
+	}
+
+	if !b.Contains(PointN{2, 5, 1}) || !b.Contains(PointN{1, 2, 0}) { // Edge and inside
+		t.Error("Box to point collision is not correct")
+	}
+	if b.Contains(PointN{1, 2, 1.5}) { // Outside on a single dimension
+		t.Error("Box to point collision is not correct")
+	}
+
+	around := boxAround(PointN{3, 0, 0}, PointN{1, 1, 1})
+	if !b.Intersects(around) { // Touching on edge
+		t.Error("Box to box collision is not correct")
+	}
+	if b.Intersects(boxAround(PointN{3, 0, 0}, PointN{0.5, math.Inf(1), math.Inf(1)})) {
+		t.Error("Box to box collision is not correct")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step of a clustering run
+type ProgressPhase int
+
+const (
+	PhaseClustering ProgressPhase = iota // Workers are processing the jobs
+	PhaseMerging                         // Sets of united core points are turned into clusters
+	PhaseDone                            // Clusters are final
+)
+
+func (p ProgressPhase) String() string {
+	return [...]string{"clustering", "merging", "done"}[p]
+}
+
+func (p ProgressPhase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *ProgressPhase) UnmarshalText(text []byte) error {
+	for phase := PhaseClustering; phase <= PhaseDone; phase++ {
+		if phase.String() == string(text) {
+			*p = phase
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown progress phase %q", text)
+}
+
+// Progress of a clustering run, sent after every job and regularly while building the clusters
+type Progress struct {
+	Phase       ProgressPhase `json:"phase"`
+	JobsDone    int           `json:"jobsDone"`
+	JobsTotal   int           `json:"jobsTotal"`
+	PointsDone  int           `json:"pointsDone"`
+	PointsTotal int           `json:"pointsTotal"`
+	CorePoints  int           `json:"corePoints"` // Core locations found so far
+	Clusters    int           `json:"clusters"`   // Clusters found so far, only while merging
+	MergeDone   int           `json:"mergeDone"`  // Locations put in their cluster so far, only while merging
+	MergeTotal  int           `json:"mergeTotal"`
+	Workers     []WorkerStats `json:"workers,omitempty"` // Set once every job is done
+}
+
+// Completion of the current phase, from 0 to 1
+func (p Progress) Fraction() float64 {
+	switch {
+	case p.Phase == PhaseClustering && p.PointsTotal > 0:
+		return float64(p.PointsDone) / float64(p.PointsTotal)
+	case p.Phase == PhaseMerging && p.MergeTotal > 0:
+		return float64(p.MergeDone) / float64(p.MergeTotal)
+	case p.Phase == PhaseDone:
+		return 1
+	}
+	return 0
+}
+
+// Keeps the progress of a run and hands it to the callback, one event at a time
+// The callback runs without the lock of the progress, so a slow callback never blocks a change.
+// A nil callback (or reporter) turns reporting off.
+type progressReporter struct {
+	mu       sync.Mutex
+	progress Progress
+	reportMu sync.Mutex // Held while the callback runs, events are sent in the order of the changes
+	report   func(Progress)
+}
+
+func newProgressReporter(report func(Progress)) *progressReporter {
+	if report == nil {
+		return nil
+	}
+	return &progressReporter{report: report}
+}
+
+// Changes the progress and reports it
+func (r *progressReporter) update(change func(p *Progress)) {
+	r.change(change)
+	r.flush()
+}
+
+// Changes the progress without reporting it, cheap enough to call with other locks held
+func (r *progressReporter) change(change func(p *Progress)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	change(&r.progress)
+	r.mu.Unlock()
+}
+
+// Reports the current progress
+func (r *progressReporter) flush() {
+	if r == nil {
+		return
+	}
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+	r.mu.Lock()
+	progress := r.progress
+	r.mu.Unlock()
+	r.report(progress)
+}
+
+// Draws a percentage bar on a terminal, redrawn at most every 100ms (phase changes are always drawn)
+func progressBar(w io.Writer) func(Progress) {
+	const width = 30
+	var last time.Time
+	lastPhase := ProgressPhase(-1)
+	return func(p Progress) {
+		if p.Phase == lastPhase && time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		if lastPhase != -1 && p.Phase != lastPhase {
+			fmt.Fprintln(w) // Keep the bar of the finished phase
+		}
+		last, lastPhase = time.Now(), p.Phase
+
+		filled := int(p.Fraction() * width)
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+		switch p.Phase {
+		case PhaseClustering:
+			fmt.Fprintf(w, "\r%s %3.0f%% Clustering | Jobs: %d/%d | Points: %d/%d | Core points: %d ",
+				bar, p.Fraction()*100, p.JobsDone, p.JobsTotal, p.PointsDone, p.PointsTotal, p.CorePoints)
+		case PhaseMerging:
+			fmt.Fprintf(w, "\r%s %3.0f%% Merging | Checked: %d/%d | Clusters: %d ",
+				bar, p.Fraction()*100, p.MergeDone, p.MergeTotal, p.Clusters)
+		case PhaseDone:
+			fmt.Fprintf(w, "\r%s 100%% Done | Clusters: %d\n", bar, p.Clusters)
+		}
+	}
+}
+
+// Writes every event as a line of JSON
+func progressJSON(w io.Writer) func(Progress) {
+	encoder := json.NewEncoder(w)
+	return func(p Progress) {
+		encoder.Encode(p)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressEvents(t *testing.T) {
+	points, weights := randomDataset(rand.New(rand.NewSource(7)), 5_000)
+	bsp := NewBSPTreeFromWeightedPoints(pointsBounds(points), &points, weights)
+
+	events := []Progress{}
+	clusters, _ := dbscanClusters(context.Background(), bsp, nil, 1, 5, 100, 4, func(p Progress) {
+		events = append(events, p) // Events are serialized by the reporter
+	})
+	if len(events) == 0 {
+		t.Fatal("No progress reported")
+	}
+
+	for i := 1; i < len(events); i++ {
+		prev, p := events[i-1], events[i]
+		if p.Phase < prev.Phase {
+			t.Errorf("Event %d: phase went back from %v to %v", i, prev.Phase, p.Phase)
+		}
+		if p.JobsDone < prev.JobsDone || p.PointsDone < prev.PointsDone || p.MergeDone < prev.MergeDone {
+			t.Errorf("Event %d: progress went back: %+v after %+v", i, p, prev)
+		}
+	}
+
+	last := events[len(events)-1]
+	if last.Phase != PhaseDone || last.Clusters != len(clusters) || last.Fraction() != 1 {
+		t.Errorf("Last event %+v, expected done with %d clusters", last, len(clusters))
+	}
+	if last.JobsDone != last.JobsTotal || last.PointsDone != last.PointsTotal || last.PointsTotal != bsp.size {
+		t.Errorf("Last event %+v, expected every job and every one of the %d locations done", last, bsp.size)
+	}
+	if last.MergeDone != last.MergeTotal {
+		t.Errorf("Last event %+v, expected every piece checked", last)
+	}
+}
+
+func TestProgressFraction(t *testing.T) {
+	tests := []struct {
+		p    Progress
+		want float64
+	}{
+		{Progress{}, 0},
+		{Progress{Phase: PhaseClustering, PointsDone: 25, PointsTotal: 100}, 0.25},
+		{Progress{Phase: PhaseMerging, PointsDone: 100, PointsTotal: 100, MergeDone: 1, MergeTotal: 4}, 0.25},
+		{Progress{Phase: PhaseMerging}, 0},
+		{Progress{Phase: PhaseDone}, 1},
+	}
+	for _, test := range tests {
+		if got := test.p.Fraction(); got != test.want {
+			t.Errorf("Fraction of %+v: got %f, expected %f", test.p, got, test.want)
+		}
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	var buf bytes.Buffer
+	report := progressJSON(&buf)
+	report(Progress{Phase: PhaseClustering, JobsDone: 1, JobsTotal: 2})
+	report(Progress{Phase: PhaseDone, Clusters: 3})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["phase"] != "done" || event["clusters"] != 3.0 {
+		t.Errorf("Unexpected event %s", lines[1])
+	}
+}
+
+func TestProgressNilReporter(t *testing.T) {
+	reporter := newProgressReporter(nil)
+	reporter.update(func(p *Progress) { t.Error("Nil reporter should not change the progress") })
+}
+
+func TestProgressSlowCallback(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	events := []int{}
+	reporter := newProgressReporter(func(p Progress) {
+		if p.JobsTotal == 1 {
+			started <- true
+			<-release
+		}
+		events = append(events, p.JobsTotal)
+	})
+	go reporter.update(func(p *Progress) { p.JobsTotal++ })
+	<-started
+
+	// The callback is stuck on the first event, changes still go through
+	changed := make(chan bool)
+	go func() {
+		reporter.change(func(p *Progress) { p.JobsTotal++ })
+		changed <- true
+	}()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("A slow callback blocks the changes")
+	}
+	release <- true
+	reporter.flush()
+	if len(events) != 2 || events[0] != 1 || events[1] != 2 {
+		t.Errorf("Expected the events 1 and 2, got %v", events)
+	}
+}
+
+// In json mode stdout only holds the events, the warnings about the input go to stderr
+func TestDBSCANCommandJSON(t *testing.T) {
+	dir := t.TempDir()
+	data := strings.Builder{}
+	data.WriteString("a,b,c,d,e,f,g,h,lon,lat,passengers\n")
+	rng := rand.New(rand.NewSource(8))
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&data, "0,0,0,0,0,0,0,0,%f,%f,1\n", float64(i%3)*10+rng.Float64(), rng.Float64())
+	}
+	data.WriteString("0,0,0,0,0,0,0,0,1,1,oops\n") // Bad weight, warned about
+	if err := os.WriteFile(filepath.Join(dir, "data.csv"), []byte(data.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The command writes its results in the working directory
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		text, _ := io.ReadAll(r)
+		output <- text
+	}()
+	dbscanCommand([]string{"-progress", "json", "-weightColumn", "10", "./data.csv", "0.5", "5", "0", "2"})
+	os.Stdout = stdout
+	w.Close()
+
+	lines := strings.Split(strings.TrimSpace(string(<-output)), "\n")
+	var last Progress
+	for _, line := range lines {
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatalf("Line %q is not an event: %v", line, err)
+		}
+	}
+	if last.Phase != PhaseDone || last.Clusters != 3 {
+		t.Errorf("Expected 3 clusters in the last event, got %+v", last)
+	}
+}
@@ -11,14 +11,30 @@ func (r Rect) Centroid() Point {
 	return Point{r.x + r.w/2, r.y + r.h/2}
 }
 
-// Merges two rectangles
+// Merges two rectangles, rounded up so that the merge contains both
 func (r Rect) Merge(other Rect) Rect {
-	return Rect{
-		x: math.Min(r.x, other.x),
-		y: math.Min(r.y, other.y),
-		w: math.Max(r.x+r.w, other.x+other.w) - math.Min(r.x, other.x),
-		h: math.Max(r.y+r.h, other.y+other.h) - math.Min(r.y, other.y),
+	return boundsRect(math.Min(r.x, other.x), math.Min(r.y, other.y),
+		math.Max(r.x+r.w, other.x+other.w), math.Max(r.y+r.h, other.y+other.h))
+}
+
+// Distance from the rect to a point, 0 inside
+func (r Rect) Distance(p Point) float64 {
+	dx := math.Max(math.Max(r.x-p.x, p.x-(r.x+r.w)), 0)
+	dy := math.Max(math.Max(r.y-p.y, p.y-(r.y+r.h)), 0)
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// Halves of the rect, as the BSPTree splits it
+// If rect is vertical rectangle split vertically, else split horizontally
+func (r Rect) halves() (Rect, Rect) {
+	ratio := r.w / r.h
+	if ratio >= 1 { // Split vertically
+		w := r.w / 2
+		return Rect{r.x, r.y, w, r.h}, Rect{r.x + w, r.y, w, r.h}
 	}
+	// Split horizontally
+	h := r.h / 2
+	return Rect{r.x, r.y, r.w, h}, Rect{r.x, r.y + h, r.w, h}
 }
 
 // Add a padding to the rect
@@ -30,3 +46,35 @@ func (r Rect) Expand(amount float64) Rect {
 		h: r.h + amount*2,
 	}
 }
+
+// Returns the bounding box of a list of points
+func pointsBounds(points []Point) Rect {
+	if len(points) == 0 {
+		return Rect{}
+	}
+	minX, minY, maxX, maxY := points[0].x, points[0].y, points[0].x, points[0].y
+	for _, p := range points[1:] {
+		minX = math.Min(minX, p.x)
+		minY = math.Min(minY, p.y)
+		maxX = math.Max(maxX, p.x)
+		maxY = math.Max(maxY, p.y)
+	}
+	return boundsRect(minX, minY, maxX, maxY)
+}
+
+// Returns the rect from min to max, rounded up so that x+w and y+h reach max
+func boundsRect(minX, minY, maxX, maxY float64) Rect {
+	r := Rect{minX, minY, maxX - minX, maxY - minY}
+	for r.x+r.w < maxX {
+		r.w = math.Nextafter(r.w, math.Inf(1))
+	}
+	for r.y+r.h < maxY {
+		r.h = math.Nextafter(r.h, math.Inf(1))
+	}
+	return r
+}
+
+// Grows the rect to include the point
+func (r Rect) MergePoint(p Point) Rect {
+	return boundsRect(math.Min(r.x, p.x), math.Min(r.y, p.y), math.Max(r.x+r.w, p.x), math.Max(r.y+r.h, p.y))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math/rand"
 	"testing"
 )
 
@@ -18,6 +19,18 @@ func TestRectExpand(t *testing.T) {
 	}
 }
 
+func TestRectDistance(t *testing.T) {
+	r := Rect{0, 0, 10, 10}
+	for _, c := range []struct {
+		p    Point
+		dist float64
+	}{{Point{5, 5}, 0}, {Point{10, 0}, 0}, {Point{-3, 5}, 3}, {Point{13, 14}, 5}} {
+		if d := r.Distance(c.p); d != c.dist {
+			t.Errorf("Distance from %v to %v is %f instead of %f", r, c.p, d, c.dist)
+		}
+	}
+}
+
 func TestRectCentroid(t *testing.T) {
 	r := Rect{0, 0, 10, 10}
 	p := r.Centroid()
@@ -48,3 +61,31 @@ func TestMergeRect(t *testing.T) {
 		t.Errorf("Merge failed: %v", r)
 	}
 }
+
+func TestPointsBounds(t *testing.T) {
+	r := pointsBounds([]Point{{1, 5}, {-2, 3}, {4, -1}})
+	if r.x != -2 || r.y != -1 || r.w != 6 || r.h != 6 {
+		t.Errorf("Bounds failed: %v", r)
+	}
+
+	r = pointsBounds(nil)
+	if r != (Rect{}) {
+		t.Errorf("Bounds of nothing failed: %v", r)
+	}
+}
+
+func TestPointsBoundsContainsPoints(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		points := []Point{{rng.Float64() * 100, -rng.Float64()}, {rng.Float64(), rng.Float64() * 1e6}}
+		r := pointsBounds(points)
+		for _, p := range points {
+			if !rectPointIntersect(r, p) {
+				t.Fatalf("Bounds %v do not contain %v", r, p)
+			}
+		}
+		if merged := r.MergePoint(Point{-rng.Float64() * 1e3, 0.3}); !rectPointIntersect(merged, points[1]) {
+			t.Fatalf("Merged bounds %v lost %v", merged, points[1])
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// RTree is a 2d spatial index of locations grouped in nested bounding rects
+// Built from a tree, it is bulk loaded with Sort-Tile-Recursive (STR): the locations are sorted
+// in vertical slices, then by y within every slice, and packed into full nodes, level by level.
+// Inserts go to the node that grows the least and split full nodes in two halves.
+type RTree struct {
+	root *rtreeNode
+	size int
+	ids  int // Ids handed out so far
+}
+
+type rtreeNode struct {
+	rect     Rect
+	children []*rtreeNode   // Only on inner nodes
+	points   []BSPTreePoint // Only on leaves
+	leaf     bool
+}
+
+// Entries of a node, nodes are split past this
+const rtreeNodeSize = 16
+
+// Create a new empty RTree
+func NewRTree() *RTree {
+	return &RTree{root: &rtreeNode{leaf: true}}
+}
+
+// Create a new RTree holding the locations of a tree (with their ids)
+func NewRTreeFromTree(bsp *BSPTree) *RTree {
+	points := bsp.Query(bsp.rect)
+	t := &RTree{size: bsp.size, ids: bsp.IDs()}
+
+	// Leaves, then the levels above them until a single node is left
+	nodes := []*rtreeNode{}
+	order := strOrder(len(points), func(i int) Point { return *points[i].Point })
+	for start := 0; start < len(order); start += rtreeNodeSize {
+		leaf := &rtreeNode{leaf: true}
+		for _, i := range order[start:minInt(start+rtreeNodeSize, len(order))] {
+			leaf.points = append(leaf.points, points[i])
+		}
+		leaf.updateRect()
+		nodes = append(nodes, leaf)
+	}
+	for len(nodes) > 1 {
+		level := nodes
+		nodes = []*rtreeNode{}
+		order := strOrder(len(level), func(i int) Point { return level[i].rect.Centroid() })
+		for start := 0; start < len(order); start += rtreeNodeSize {
+			node := &rtreeNode{}
+			for _, i := range order[start:minInt(start+rtreeNodeSize, len(order))] {
+				node.children = append(node.children, level[i])
+			}
+			node.updateRect()
+			nodes = append(nodes, node)
+		}
+	}
+
+	t.root = &rtreeNode{leaf: true}
+	if len(nodes) == 1 {
+		t.root = nodes[0]
+	}
+	return t
+}
+
+// Sort-Tile-Recursive order of n items: sqrt(n / rtreeNodeSize) vertical slices sorted by x, each sorted by y
+func strOrder(n int, center func(i int) Point) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return center(order[i]).x < center(order[j]).x
+	})
+	nodes := (n + rtreeNodeSize - 1) / rtreeNodeSize
+	slice := int(math.Ceil(math.Sqrt(float64(nodes)))) * rtreeNodeSize // Items per slice
+	for start := 0; start < n; start += slice {
+		s := order[start:minInt(start+slice, n)]
+		sort.Slice(s, func(i, j int) bool {
+			return center(s[i]).y < center(s[j]).y
+		})
+	}
+	return order
+}
+
+// Recomputes the bounds of the node from its entries
+func (n *rtreeNode) updateRect() {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range n.points {
+		minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+		maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+	}
+	for _, c := range n.children {
+		minX, minY = math.Min(minX, c.rect.x), math.Min(minY, c.rect.y)
+		maxX, maxY = math.Max(maxX, c.rect.x+c.rect.w), math.Max(maxY, c.rect.y+c.rect.h)
+	}
+	if n.entries() == 0 {
+		n.rect = Rect{}
+		return
+	}
+	n.rect = boundsRect(minX, minY, maxX, maxY)
+}
+
+func (n *rtreeNode) entries() int {
+	return len(n.points) + len(n.children)
+}
+
+// Location at p, nil if there is none
+func (n *rtreeNode) find(p *Point) *BSPTreePoint {
+	if n.entries() == 0 || !rectPointIntersect(n.rect, *p) {
+		return nil
+	}
+	for i := range n.points {
+		if pointIntersect(*n.points[i].Point, *p) {
+			return &n.points[i]
+		}
+	}
+	for _, c := range n.children {
+		if l := c.find(p); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// RTree insert
+func (t *RTree) Insert(p *Point) {
+	t.size++
+	if l := t.root.find(p); l != nil {
+		l.cnt++
+		l.weight++
+		return
+	}
+	if sibling := t.root.insert(BSPTreePoint{p, 1, 1, t.ids}); sibling != nil { // The root was split, grow a level
+		t.root = &rtreeNode{children: []*rtreeNode{t.root, sibling}}
+		t.root.updateRect()
+	}
+	t.ids++
+}
+
+// Adds a new location under the node, returns the new sibling of the node if it had to be split
+func (n *rtreeNode) insert(p BSPTreePoint) *rtreeNode {
+	if n.entries() == 0 {
+		n.rect = Rect{p.x, p.y, 0, 0}
+	} else {
+		n.rect = n.rect.MergePoint(*p.Point)
+	}
+	if n.leaf {
+		n.points = append(n.points, p)
+	} else {
+		// The child that grows the least, then the smallest one
+		best, growth, area := 0, math.Inf(1), math.Inf(1)
+		for i, c := range n.children {
+			a := c.rect.w * c.rect.h
+			merged := c.rect.MergePoint(*p.Point)
+			if g := merged.w*merged.h - a; g < growth || g == growth && a < area {
+				best, growth, area = i, g, a
+			}
+		}
+		if sibling := n.children[best].insert(p); sibling != nil {
+			n.children = append(n.children, sibling)
+		}
+	}
+	if n.entries() > rtreeNodeSize {
+		return n.split()
+	}
+	return nil
+}
+
+// Moves the half of the entries on the right (or the top) of the node to a new sibling
+func (n *rtreeNode) split() *rtreeNode {
+	byX := n.rect.w >= n.rect.h
+	coord := func(p Point) float64 {
+		if byX {
+			return p.x
+		}
+		return p.y
+	}
+	sibling := &rtreeNode{leaf: n.leaf}
+	if n.leaf {
+		sort.Slice(n.points, func(i, j int) bool {
+			return coord(*n.points[i].Point) < coord(*n.points[j].Point)
+		})
+		half := len(n.points) / 2
+		sibling.points = append(sibling.points, n.points[half:]...)
+		n.points = n.points[:half:half]
+	} else {
+		sort.Slice(n.children, func(i, j int) bool {
+			return coord(n.children[i].rect.Centroid()) < coord(n.children[j].rect.Centroid())
+		})
+		half := len(n.children) / 2
+		sibling.children = append(sibling.children, n.children[half:]...)
+		n.children = n.children[:half:half]
+	}
+	n.updateRect()
+	sibling.updateRect()
+	return sibling
+}
+
+// RTree remove
+// Empty nodes are dropped, the others are not rebalanced.
+func (t *RTree) Remove(p *Point) bool {
+	if !t.root.remove(p) {
+		return false
+	}
+	t.size--
+	for !t.root.leaf && len(t.root.children) == 1 { // Shrink the levels left with a single node
+		t.root = t.root.children[0]
+	}
+	if t.root.entries() == 0 {
+		t.root = &rtreeNode{leaf: true}
+	}
+	return true
+}
+
+func (n *rtreeNode) remove(p *Point) bool {
+	if n.entries() == 0 || !rectPointIntersect(n.rect, *p) {
+		return false
+	}
+	for i := range n.points {
+		if !pointIntersect(*n.points[i].Point, *p) {
+			continue
+		}
+		n.points[i].cnt--
+		n.points[i].weight--
+		if n.points[i].cnt == 0 {
+			n.points = append(n.points[:i], n.points[i+1:]...)
+			n.updateRect()
+		}
+		return true
+	}
+	for i, c := range n.children {
+		if !c.remove(p) {
+			continue
+		}
+		if c.entries() == 0 {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+		}
+		n.updateRect()
+		return true
+	}
+	return false
+}
+
+// Calls visit on the locations of the nodes intersecting r, until visit returns false
+func (t *RTree) walk(r Rect, visit func(n BSPTreePoint) bool) {
+	t.root.walk(r, visit)
+}
+
+func (n *rtreeNode) walk(r Rect, visit func(n BSPTreePoint) bool) bool {
+	if n.entries() == 0 || !rectIntersect(n.rect, r) {
+		return true
+	}
+	for _, p := range n.points {
+		if !visit(p) {
+			return false
+		}
+	}
+	for _, c := range n.children {
+		if !c.walk(r, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *RTree) RangeQuery(r Rect, points []BSPTreePoint) []BSPTreePoint {
+	return walkRangeQuery(t.walk, r, points)
+}
+
+func (t *RTree) RadiusQuery(p Point, radius float64, points []BSPTreePoint) []BSPTreePoint {
+	return walkRadiusQuery(t.walk, p, radius, points)
+}
+
+func (t *RTree) WeightWithin(p Point, radius float64, limit float64) float64 {
+	return walkWeightWithin(t.walk, p, radius, limit)
+}
+
+// Visits the closest nodes first and skips the ones further away than the k-th location found so far
+func (t *RTree) KNN(p Point, k int) []BSPTreePoint {
+	if k <= 0 {
+		return nil
+	}
+	res := newKNNResult(p, k)
+	t.root.knn(res)
+	return res.points
+}
+
+func (n *rtreeNode) knn(res *knnResult) {
+	if n.entries() == 0 || n.rect.Distance(res.p) > res.worst() {
+		return
+	}
+	for _, p := range n.points {
+		res.add(p)
+	}
+	children := append([]*rtreeNode{}, n.children...)
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].rect.Distance(res.p) < children[j].rect.Distance(res.p)
+	})
+	for _, c := range children {
+		c.knn(res)
+	}
+}
+
+func (t *RTree) Bounds() Rect {
+	return t.root.rect
+}
+
+func (t *RTree) Size() int {
+	return t.size
+}
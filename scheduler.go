@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Jobs are not split below this many points, the pieces would cost more to schedule than to cluster
+const minSplitSize = 64
+
+// Size limit of the jobs when maxJobSize is 0
+// The cost-based splits only share the work between the workers, this keeps the jobs small enough
+// for the progress (reported after every job) to move regularly, even with a single worker.
+const autoJobSize = 1_000
+
+// WorkerStats tells how busy a worker of the scheduler was
+type WorkerStats struct {
+	Jobs        int           `json:"jobs"`
+	Points      int           `json:"points"`
+	Steals      int           `json:"steals"` // Jobs taken from the queue of another worker
+	Splits      int           `json:"splits"` // Jobs split in two before being processed
+	Busy        time.Duration `json:"busy"`
+	Utilization float64       `json:"utilization"` // Busy time over the time of the whole run
+}
+
+// A job to cluster and its estimated cost
+type schedJob struct {
+	part dbscanJob
+	cost float64
+}
+
+// Work-stealing scheduler of the DBSCAN jobs
+// Every worker has its own queue: it works from the end of it and idle workers steal from the start.
+// Jobs that cost too much compared to the work left are split before being processed, so that the
+// last jobs are small enough to keep every worker busy.
+type scheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queues     [][]schedJob
+	remaining  float64 // Estimated cost of the queued and running jobs
+	running    int
+	state      *dbscanState
+	epsilon    float64
+	minPts     int
+	maxJobSize int // Jobs above this size are always split (0 for autoJobSize)
+	stats      []WorkerStats
+}
+
+// Estimated cost of clustering a subtree: every point queries its neighbors,
+// assuming they are spread evenly over the subtree bounds
+func jobCost(tree *BSPTree, epsilon float64) float64 {
+	size := float64(tree.size)
+	area := tree.rect.w * tree.rect.h
+	neighbors := size
+	if area > 0 {
+		neighbors = size * 4 * epsilon * epsilon / area
+		if neighbors > size {
+			neighbors = size
+		}
+	}
+	return size * (1 + neighbors)
+}
+
+// Hands out the jobs to the workers, largest first to the least loaded worker
+func newScheduler(state *dbscanState, jobs []dbscanJob, epsilon float64, minPts int, maxJobSize int, nWorkers int) *scheduler {
+	s := &scheduler{
+		queues:     make([][]schedJob, nWorkers),
+		state:      state,
+		epsilon:    epsilon,
+		minPts:     minPts,
+		maxJobSize: maxJobSize,
+		stats:      make([]WorkerStats, nWorkers),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	sorted := make([]schedJob, len(jobs))
+	for i, job := range jobs {
+		sorted[i] = schedJob{job, job.cost(epsilon)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].cost > sorted[j].cost
+	})
+	load := make([]float64, nWorkers)
+	for _, job := range sorted {
+		w := 0
+		for i := range load {
+			if load[i] < load[w] {
+				w = i
+			}
+		}
+		s.queues[w] = append(s.queues[w], job) // Largest first, so thieves steal the largest jobs
+		load[w] += job.cost
+		s.remaining += job.cost
+	}
+	return s
+}
+
+// Takes the next job of a worker: from the end of its own queue, otherwise from the start of
+// the queue with the most work left. Must be called with the lock held.
+func (s *scheduler) take(w int) (schedJob, bool) {
+	if n := len(s.queues[w]); n > 0 {
+		job := s.queues[w][n-1]
+		s.queues[w] = s.queues[w][:n-1]
+		return job, true
+	}
+
+	victim, most := -1, 0.0
+	for i, queue := range s.queues {
+		cost := 0.0
+		for _, job := range queue {
+			cost += job.cost
+		}
+		if len(queue) > 0 && (victim == -1 || cost > most) {
+			victim, most = i, cost
+		}
+	}
+	if victim == -1 {
+		return schedJob{}, false
+	}
+	job := s.queues[victim][0]
+	s.queues[victim] = s.queues[victim][1:]
+	s.stats[w].Steals++
+	return job, true
+}
+
+// Should the job be split before being processed? Must be called with the lock held.
+func (s *scheduler) shouldSplit(job schedJob) bool {
+	if left, _ := job.part.halves(); left == nil {
+		return false
+	}
+	limit := s.maxJobSize
+	if limit == 0 {
+		limit = autoJobSize
+	}
+	if job.part.size() > limit {
+		return true
+	}
+	// A single worker has no one to share the work with
+	if len(s.queues) == 1 || job.part.size() <= minSplitSize {
+		return false
+	}
+	return job.cost > s.remaining/float64(2*len(s.queues))
+}
+
+// Splits the job until it is small enough, the other halves go to the end of the worker queue
+// (where idle workers can steal them). Returns the number of splits. Must be called with the lock held.
+func (s *scheduler) split(w int, job schedJob) (schedJob, int) {
+	splits := 0
+	for s.shouldSplit(job) {
+		s.remaining -= job.cost
+		halves := []schedJob{}
+		left, right := job.part.halves()
+		for _, child := range []dbscanJob{left, right} {
+			if child.size() > 0 {
+				half := schedJob{child, child.cost(s.epsilon)}
+				s.remaining += half.cost
+				halves = append(halves, half)
+			}
+		}
+		if len(halves) == 1 { // Nothing to share, keep going down
+			job = halves[0]
+			continue
+		}
+		sort.Slice(halves, func(i, j int) bool {
+			return halves[i].cost > halves[j].cost
+		})
+		s.queues[w] = append(s.queues[w], halves[0])
+		job = halves[1]
+		splits++
+		s.stats[w].Splits++
+	}
+	return job, splits
+}
+
+// Runs nWorkers workers until every job is done or the context is done
+// Returns the stats of every worker.
+func (s *scheduler) run(ctx context.Context, progress *progressReporter) []WorkerStats {
+	start := time.Now()
+	finished := make(chan struct{})
+	go func() { // Wake up the idle workers so they see the context is done
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-finished:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := range s.queues {
+		wg.Add(1)
+		go func(w int) {
+			s.work(ctx, w, progress)
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+	close(finished)
+
+	elapsed := time.Since(start)
+	for i := range s.stats {
+		if elapsed > 0 {
+			s.stats[i].Utilization = float64(s.stats[i].Busy) / float64(elapsed)
+		}
+	}
+	return s.stats
+}
+
+// Worker loop, waits for work while other workers may still split their jobs
+func (s *scheduler) work(ctx context.Context, w int, progress *progressReporter) {
+	for {
+		s.mu.Lock()
+		job, ok := s.take(w)
+		for !ok && s.running > 0 && ctx.Err() == nil {
+			s.cond.Wait()
+			job, ok = s.take(w)
+		}
+		if !ok || ctx.Err() != nil {
+			s.cond.Broadcast() // Nothing left, let the others stop too
+			s.mu.Unlock()
+			return
+		}
+		job, splits := s.split(w, job)
+		s.running++
+		if splits > 0 {
+			s.cond.Broadcast()
+			progress.change(func(p *Progress) { // Before any of the new jobs can be done
+				p.JobsTotal += splits
+			})
+		}
+		s.mu.Unlock()
+		if splits > 0 {
+			progress.flush() // The callback may be slow, the other workers don't wait for it
+		}
+
+		startT := time.Now()
+		found := job.part.run(ctx, s.state, s.epsilon, s.minPts)
+		busy := time.Since(startT)
+
+		s.mu.Lock()
+		s.running--
+		s.remaining -= job.cost
+		s.stats[w].Busy += busy
+		if ctx.Err() == nil {
+			s.stats[w].Jobs++
+			s.stats[w].Points += job.part.size()
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return // The job was left half done
+		}
+		progress.update(func(p *Progress) {
+			p.JobsDone++
+			p.PointsDone += job.part.size()
+			p.CorePoints += found
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+// One dense blob in a sparse square, the worst case for jobs cut by size only
+func skewedPoints(n int) []Point {
+	rng := rand.New(rand.NewSource(3))
+	points := make([]Point, n)
+	for i := range points {
+		if i%10 == 0 {
+			points[i] = Point{rng.Float64() * 100, rng.Float64() * 100}
+		} else {
+			points[i] = Point{50 + rng.NormFloat64(), 50 + rng.NormFloat64()}
+		}
+	}
+	return points
+}
+
+func TestSchedulerMatchesReference(t *testing.T) {
+	points := skewedPoints(2_000)
+	for _, maxJobSize := range []int{0, 1, 100, 10_000} {
+		for _, nWorkers := range []int{1, 3, 8} {
+			checkEngine(t, points, nil, 0.2, 5, maxJobSize, nWorkers)
+		}
+	}
+}
+
+func TestSchedulerStats(t *testing.T) {
+	points := skewedPoints(10_000)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+
+	var last Progress
+	dbscanClusters(context.Background(), bsp, nil, 0.2, 5, 0, 4, func(p Progress) {
+		last = p
+	})
+	if len(last.Workers) != 4 {
+		t.Fatalf("Expected the stats of 4 workers, got %+v", last.Workers)
+	}
+
+	jobs, pointsDone, splits := 0, 0, 0
+	for _, w := range last.Workers {
+		jobs += w.Jobs
+		pointsDone += w.Points
+		splits += w.Splits
+		if w.Steals > w.Jobs {
+			t.Errorf("Worker stole more jobs than it did: %+v", w)
+		}
+		if w.Utilization < 0 || w.Utilization > 1 {
+			t.Errorf("Utilization out of [0, 1]: %+v", w)
+		}
+	}
+	if jobs != last.JobsDone || jobs != last.JobsTotal || pointsDone != bsp.size {
+		t.Errorf("Workers did %d jobs and %d points, expected %d jobs and %d points", jobs, pointsDone, last.JobsTotal, bsp.size)
+	}
+	if splits == 0 || last.JobsTotal != splits+1 { // The whole tree is the only job to start with
+		t.Errorf("Expected the tree to be split, got %d splits for %d jobs", splits, last.JobsTotal)
+	}
+}
+
+func TestSchedulerSteal(t *testing.T) {
+	points := skewedPoints(1_000)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	jobs := dbscanJobs(bspJob{bsp, bsp}, 100)
+	s := newScheduler(newDBSCANState(bsp.IDs()), jobs, 0.2, 5, 100, 2)
+
+	// Drain the queue of the first worker, it then steals the largest job of the second one
+	for len(s.queues[0]) > 0 {
+		s.take(0)
+	}
+	largest := s.queues[1][0]
+	for _, job := range s.queues[1] {
+		if job.cost > largest.cost {
+			t.Errorf("Job of cost %f queued after a larger job of cost %f", job.cost, largest.cost)
+		}
+	}
+	job, ok := s.take(0)
+	if !ok || job.part != largest.part || s.stats[0].Steals != 1 {
+		t.Errorf("Expected the first worker to steal the largest job, got %v (%d steals)", ok, s.stats[0].Steals)
+	}
+
+	for len(s.queues[1]) > 0 {
+		s.take(1)
+	}
+	if _, ok := s.take(0); ok {
+		t.Error("Took a job from empty queues")
+	}
+}
+
+func TestSchedulerSplit(t *testing.T) {
+	points := skewedPoints(5_000)
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+	s := newScheduler(newDBSCANState(bsp.IDs()), []dbscanJob{bspJob{bsp, bsp}}, 0.2, 5, 0, 4)
+
+	job, _ := s.take(0)
+	job, splits := s.split(0, job)
+	if splits == 0 || len(s.queues[0]) != splits {
+		t.Errorf("Expected the other halves in the queue, got %d splits and %d queued jobs", splits, len(s.queues[0]))
+	}
+	if s.shouldSplit(job) {
+		t.Errorf("Job of cost %f should be small enough, %f left", job.cost, s.remaining)
+	}
+	size := job.part.size()
+	for _, queued := range s.queues[0] {
+		size += queued.part.size()
+	}
+	if size != bsp.size {
+		t.Errorf("Jobs hold %d points, expected %d", size, bsp.size)
+	}
+
+	// A single worker only splits down to the size limit
+	s = newScheduler(newDBSCANState(bsp.IDs()), []dbscanJob{bspJob{bsp, bsp}}, 0.2, 5, 0, 1)
+	job, _ = s.take(0)
+	job, _ = s.split(0, job)
+	for len(s.queues[0]) > 0 {
+		if job.part.size() > autoJobSize {
+			t.Errorf("Single worker kept a job of %d points, expected at most %d", job.part.size(), autoJobSize)
+		}
+		job, _ = s.take(0)
+		job, _ = s.split(0, job)
+	}
+}
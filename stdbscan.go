@@ -0,0 +1,42 @@
+package main
+
+import "time"
+
+// Returns the points within epsilon in space and epsilonT seconds in time of point i (itself included)
+// The tree holds x, y and time (in seconds) of every point
+func stNeighbors(tree *PartitionTree, i int, epsilon float64, epsilonT float64) []int {
+	p := tree.points[i]
+	res := []int{}
+	for _, j := range tree.Query(boxAround(p, PointN{epsilon, epsilon, epsilonT})) {
+		q := tree.points[j]
+		dx, dy := q[0]-p[0], q[1]-p[1]
+		if dx*dx+dy*dy <= epsilon*epsilon {
+			res = append(res, j) // Time is already within the box
+		}
+	}
+	return res
+}
+
+// ST-DBSCAN: DBSCAN where two points are neighbors only if they are within epsilon in space
+// and within epsilonT in time. A point is a core point if it has at least minPts neighbors (itself included).
+// Returns the cluster of every point, starting at 1, 0 means noise.
+func stdbscan(points []TimedPoint, epsilon float64, epsilonT time.Duration, minPts int) []int {
+	epsT := epsilonT.Seconds()
+	coords := make([]PointN, len(points))
+	for i, p := range points {
+		coords[i] = PointN{p.x, p.y, float64(p.t.UnixNano()) / 1e9}
+	}
+
+	// Split on whichever of x, y or time is the widest relative to its epsilon
+	scale := PointN{epsilon, epsilon, epsT}
+	for d := range scale {
+		if scale[d] <= 0 {
+			scale[d] = 1
+		}
+	}
+	tree := NewPartitionTree(coords, scale)
+
+	return dbscanLabels(len(points), nil, func(i int) []int {
+		return stNeighbors(tree, i, epsilon, epsT)
+	}, minPts)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSTDBSCANSeparatesTimes(t *testing.T) {
+	morning := time.Date(2022, 8, 1, 8, 0, 0, 0, time.UTC)
+	evening := morning.Add(12 * time.Hour)
+	points := []TimedPoint{}
+	for i := 0; i < 10; i++ {
+		p := Point{float64(i%3) * 0.1, float64(i/3) * 0.1}
+		points = append(points, TimedPoint{p, morning.Add(time.Duration(i) * time.Minute)})
+		points = append(points, TimedPoint{p, evening.Add(time.Duration(i) * time.Minute)})
+	}
+	points = append(points, TimedPoint{Point{0, 0}, morning.Add(6 * time.Hour)}) // Same corner, nobody around
+
+	labels := stdbscan(points, 0.15, 5*time.Minute, 4)
+	if labels[0] == 0 || labels[1] == 0 || labels[0] == labels[1] {
+		t.Errorf("Morning and evening were not split: %v", labels)
+	}
+	for i := 2; i < 20; i++ {
+		if labels[i] != labels[i%2] {
+			t.Errorf("Point %d is in cluster %d instead of %d", i, labels[i], labels[i%2])
+		}
+	}
+	if labels[20] != 0 {
+		t.Error("Isolated point is not noise")
+	}
+}
+
+func TestSTNeighbors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]TimedPoint, 1000)
+	coords := make([]PointN, len(points))
+	for i := range points {
+		p := Point{float64(rng.Intn(20)), float64(rng.Intn(20))} // Duplicates on purpose
+		points[i] = TimedPoint{p, start.Add(time.Duration(rng.Intn(600)) * time.Second)}
+		coords[i] = PointN{p.x, p.y, float64(points[i].t.Unix())}
+	}
+
+	epsilon, epsilonT := 2.0, 60.0
+	tree := NewPartitionTree(coords, PointN{epsilon, epsilon, epsilonT})
+	for i := range points {
+		expected := 0
+		for j := range points {
+			dt := points[i].t.Sub(points[j].t).Seconds()
+			if points[i].Distance(points[j].Point) <= epsilon && math.Abs(dt) <= epsilonT {
+				expected++
+			}
+		}
+		if n := stNeighbors(tree, i, epsilon, epsilonT); len(n) != expected {
+			t.Fatalf("Point %d has %d neighbors instead of %d", i, len(n), expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"dbscan/evaluate"
+)
+
+// Summary of one clustering of a parameter sweep
+type SweepResult struct {
+	epsilon    float64
+	minPts     int
+	clusters   int
+	noise      float64 // Fraction of the points that are noise
+	largest    int     // Number of points in the largest cluster
+	runtime    time.Duration
+	silhouette float64 // Over the clustered locations, NaN with less than 2 clusters
+}
+
+// Runs the clustering for every combination of epsilon and minPts on the same tree
+// The silhouette is computed on at most sample locations (0 for all of them) and is not part of the runtime.
+func sweep(bsp *BSPTree, epsilons []float64, minPts []int, maxJobSize int, nWorkers int, sample int) []SweepResult {
+	results := []SweepResult{}
+	for _, epsilon := range epsilons {
+		for _, m := range minPts {
+			startT := time.Now()
+			clusters, _ := dbscanClusters(context.Background(), bsp, nil, epsilon, m, maxJobSize, nWorkers, nil)
+			res := SweepResult{epsilon: epsilon, minPts: m, clusters: len(clusters), runtime: time.Since(startT)}
+
+			clustered := 0
+			points := [][]float64{}
+			labels := []int{}
+			for i, cluster := range clusters {
+				size := cluster.Size()
+				clustered += size
+				if size > res.largest {
+					res.largest = size
+				}
+				for _, p := range cluster.points {
+					points = append(points, []float64{p.x, p.y})
+					labels = append(labels, i+1)
+				}
+			}
+			if bsp.size > 0 {
+				res.noise = 1 - float64(clustered)/float64(bsp.size)
+			}
+			res.silhouette = evaluate.Silhouette(points, labels, sample, 1, nWorkers)
+			results = append(results, res)
+		}
+	}
+	return results
+}
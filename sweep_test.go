@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	// Two 5x4 grids with a 0.1 spacing, 10 apart, and a lone point
+	points := []Point{{5, 20}}
+	for i := 0; i < 20; i++ {
+		points = append(points, Point{float64(i%5) * 0.1, float64(i/5) * 0.1})
+		points = append(points, Point{10 + float64(i%5)*0.1, float64(i/5) * 0.1})
+	}
+	bsp := NewBSPTreeFromPoints(pointsBounds(points), &points)
+
+	results := sweep(bsp, []float64{0.15, 25}, []int{5, 50}, 1_000, 2, 0)
+	if len(results) != 4 {
+		t.Fatalf("Got %d results instead of 4", len(results))
+	}
+
+	res := results[0]
+	if res.epsilon != 0.15 || res.minPts != 5 || res.clusters != 2 || res.largest != 20 {
+		t.Errorf("Wrong summary for two clusters: %+v", res)
+	}
+	if math.Abs(res.noise-1.0/41) > 1e-9 || res.silhouette < 0.9 {
+		t.Errorf("Wrong noise or silhouette for two clusters: %+v", res)
+	}
+
+	// Nothing is big enough
+	if res := results[1]; res.clusters != 0 || res.noise != 1 || !math.IsNaN(res.silhouette) {
+		t.Errorf("Wrong summary without clusters: %+v", res)
+	}
+
+	// Everything is one cluster
+	if res := results[2]; res.clusters != 1 || res.largest != 41 || res.noise != 0 {
+		t.Errorf("Wrong summary for a single cluster: %+v", res)
+	}
+}
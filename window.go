@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TimedPoint is a point with the time it was recorded at
+type TimedPoint struct {
+	Point
+	t time.Time
+}
+
+// ClusterWindow holds the clusters found in a time window [start, end)
+// Clusters are sorted by size, the cluster id is the index + 1 (same as points.csv)
+type ClusterWindow struct {
+	start    time.Time
+	end      time.Time
+	points   int
+	clusters []Cluster
+}
+
+// ClusterLineage links a cluster to a cluster of the previous window that shares points with it
+type ClusterLineage struct {
+	window      int // Index of the window of the cluster
+	cluster     int
+	prevCluster int // Cluster id in window - 1
+	shared      int // Number of points found in both clusters
+}
+
+// Clusters the points over a sliding time window
+// The first window starts at the first point, truncated to the step
+func clusterWindows(points []TimedPoint, window time.Duration, step time.Duration,
+	epsilon float64, minPts int, maxJobSize int, nWorkers int) []ClusterWindow {
+	if len(points) == 0 {
+		return nil
+	}
+
+	sorted := make([]TimedPoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].t.Before(sorted[j].t)
+	})
+
+	windows := []ClusterWindow{}
+	first, last := 0, 0 // Points in the window are sorted[first:last]
+	for start := sorted[0].t.Truncate(step); !start.After(sorted[len(sorted)-1].t); start = start.Add(step) {
+		end := start.Add(window)
+		for first < len(sorted) && sorted[first].t.Before(start) {
+			first++
+		}
+		for last < len(sorted) && sorted[last].t.Before(end) {
+			last++
+		}
+		if last < first {
+			last = first
+		}
+
+		// Each window gets its own tree
+		windowPoints := make([]Point, last-first)
+		for i := range windowPoints {
+			windowPoints[i] = sorted[first+i].Point
+		}
+		clusters := []Cluster{}
+		if len(windowPoints) > 0 {
+			bsp := NewBSPTreeFromPoints(pointsBounds(windowPoints), &windowPoints)
+			clusters, _ = dbscanClusters(context.Background(), bsp, nil, epsilon, minPts, maxJobSize, nWorkers, nil)
+		}
+		sort.SliceStable(clusters, func(i, j int) bool {
+			return clusters[i].Size() > clusters[j].Size()
+		})
+
+		windows = append(windows, ClusterWindow{start, end, len(windowPoints), clusters})
+	}
+	return windows
+}
+
+// Links every cluster to the clusters of the previous window it shares points with
+func windowLineage(windows []ClusterWindow) []ClusterLineage {
+	lineage := []ClusterLineage{}
+	for w := 1; w < len(windows); w++ {
+		// Which cluster was every location in
+		prev := make(map[Point]int)
+		for i, cluster := range windows[w-1].clusters {
+			for _, p := range cluster.points {
+				prev[*p.Point] = i + 1
+			}
+		}
+
+		for i, cluster := range windows[w].clusters {
+			shared := make(map[int]int)
+			for _, p := range cluster.points {
+				if id, ok := prev[*p.Point]; ok {
+					shared[id] += p.cnt
+				}
+			}
+			ids := make([]int, 0, len(shared))
+			for id := range shared {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+			for _, id := range ids {
+				lineage = append(lineage, ClusterLineage{w, i + 1, id, shared[id]})
+			}
+		}
+	}
+	return lineage
+}
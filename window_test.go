@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClusterWindows(t *testing.T) {
+	start := time.Date(2022, 8, 1, 8, 0, 0, 0, time.UTC)
+	points := []TimedPoint{}
+	// A hotspot during the first 20 minutes and another one 10 minutes later
+	for i := 0; i < 20; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		points = append(points, TimedPoint{Point{float64(i%5) * 0.1, 0}, at})
+		points = append(points, TimedPoint{Point{10 + float64(i%5)*0.1, 10}, at.Add(10 * time.Minute)})
+	}
+
+	windows := clusterWindows(points, 10*time.Minute, 10*time.Minute, 0.15, 5, 1000, 2)
+	if len(windows) != 3 {
+		t.Fatalf("Expected 3 windows, got %d", len(windows))
+	}
+	expected := []int{1, 2, 1}
+	for i, w := range windows {
+		if !w.start.Equal(start.Add(time.Duration(i) * 10 * time.Minute)) {
+			t.Errorf("Window %d starts at %v", i, w.start)
+		}
+		if len(w.clusters) != expected[i] {
+			t.Errorf("Window %d has %d clusters instead of %d", i, len(w.clusters), expected[i])
+		}
+		if w.points != expected[i]*10 {
+			t.Errorf("Window %d has %d points", i, w.points)
+		}
+	}
+
+	lineage := windowLineage(windows)
+	if len(lineage) != 2 {
+		t.Fatalf("Expected 2 links, got %v", lineage)
+	}
+	// The first hotspot continues in window 1, the second one continues in window 2
+	first := windows[1].clusters[lineage[0].cluster-1].Average()
+	if lineage[0].window != 1 || lineage[0].prevCluster != 1 || first.y != 0 {
+		t.Errorf("Wrong lineage %v", lineage[0])
+	}
+	if lineage[1].window != 2 || lineage[1].cluster != 1 || lineage[1].shared != 10 {
+		t.Errorf("Wrong lineage %v", lineage[1])
+	}
+}